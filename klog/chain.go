@@ -25,6 +25,38 @@ func (chained *Chained) PrintNext(line *Line) {
 	}
 }
 
+// EnabledNext returns true if the next printer in the pipeline would print a
+// line with the given key. Printers which don't implement the Enabler interface
+// are assumed to print all lines.
+func (chained *Chained) EnabledNext(key string) bool {
+	return enabledPrinter(chained.Next, key)
+}
+
+// FlushNext flushes the next printer in the pipeline if it implements the
+// Flusher interface.
+func (chained *Chained) FlushNext() error {
+	return flushPrinter(chained.Next)
+}
+
+// CloseNext closes the next printer in the pipeline if it implements the Closer
+// interface.
+func (chained *Chained) CloseNext() error {
+	return closePrinter(chained.Next)
+}
+
+// Enabled returns true if the rest of the pipeline would print a line with the
+// given key. Stages which discard lines based on their keys should override
+// this to check their own state before calling EnabledNext.
+func (chained *Chained) Enabled(key string) bool { return chained.EnabledNext(key) }
+
+// Flush flushes the rest of the pipeline. Stages which buffer lines should
+// override this to flush their buffered lines before calling FlushNext.
+func (chained *Chained) Flush() error { return chained.FlushNext() }
+
+// Close closes the rest of the pipeline. Stages which hold resources should
+// override this to release them before calling CloseNext.
+func (chained *Chained) Close() error { return chained.CloseNext() }
+
 // Chain chains the given printer to the given chained printer and returns the
 // chained printer.
 func Chain(printer Chainer, next Printer) Printer {
@@ -32,11 +64,68 @@ func Chain(printer Chainer, next Printer) Printer {
 	return printer
 }
 
-// Fork duplicates all received lines to multiple printers.
+type fork []Printer
+
+// Fork duplicates all received lines to multiple printers. Flush and Close are
+// also propagated to all the printers and a key is enabled if at least one of
+// the printers has it enabled.
 func Fork(printers ...Printer) Printer {
-	return PrinterFunc(func(line *Line) {
-		for _, printer := range printers {
-			printer.Print(line)
+	return fork(printers)
+}
+
+func (printers fork) Print(line *Line) {
+	for _, printer := range printers {
+		printer.Print(line)
+	}
+}
+
+func (printers fork) Enabled(key string) bool {
+	for _, printer := range printers {
+		if enabledPrinter(printer, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (printers fork) Flush() (err error) {
+	for _, printer := range printers {
+		if flushErr := flushPrinter(printer); err == nil {
+			err = flushErr
 		}
-	})
+	}
+	return
+}
+
+func (printers fork) Close() (err error) {
+	for _, printer := range printers {
+		if closeErr := closePrinter(printer); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+func enabledPrinter(printer Printer, key string) bool {
+	if printer == nil {
+		return false
+	}
+	if enabler, ok := printer.(Enabler); ok {
+		return enabler.Enabled(key)
+	}
+	return true
+}
+
+func flushPrinter(printer Printer) error {
+	if flusher, ok := printer.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+func closePrinter(printer Printer) error {
+	if closer, ok := printer.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
@@ -0,0 +1,68 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type ClosePrinter struct {
+	TestPrinter
+	Flushes int
+	Closes  int
+}
+
+func (printer *ClosePrinter) Flush() error { printer.Flushes++; return nil }
+func (printer *ClosePrinter) Close() error { printer.Closes++; return nil }
+
+func TestChain_Close(t *testing.T) {
+	a, b := &ClosePrinter{}, &ClosePrinter{}
+
+	filter := NewFilter(FilterOut)
+	dedup := &Dedup{Rate: 1 * time.Hour}
+
+	printer := Chain(filter, Fork(a, Chain(dedup, b), NilPrinter))
+
+	if err := flushPrinter(printer); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Flushes != 1 || b.Flushes != 1 {
+		t.Errorf("FAIL: unexpected flushes %d %d", a.Flushes, b.Flushes)
+	}
+
+	if err := closePrinter(printer); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Closes != 1 || b.Closes != 1 {
+		t.Errorf("FAIL: unexpected closes %d %d", a.Closes, b.Closes)
+	}
+
+	printer.Print(L("a", "x"))
+}
+
+func TestLogger_Shutdown(t *testing.T) {
+	out := &ClosePrinter{TestPrinter: TestPrinter{T: t}}
+	dedup := &Dedup{Rate: 1 * time.Hour}
+	logger := New(Chain(NewFilter(FilterOut), Chain(dedup, out)), PrinterFunc(LogPrinter))
+
+	logger.KPrint("a", "x")
+	logger.KPrint("a", "x")
+	logger.KPrint("a", "x")
+
+	if err := logger.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Closes != 1 {
+		t.Errorf("FAIL: unexpected closes %d", out.Closes)
+	}
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<a> x [2 times]",
+	)
+}
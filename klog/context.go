@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	traceContextKey
+	fieldsContextKey
+)
+
+type traceContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// WithTrace returns a copy of the context which carries the given trace and
+// span ids. The ids will be attached to all the lines printed via the Ctx
+// family of functions using the returned context.
+func WithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceContextKey, traceContext{traceID, spanID})
+}
+
+// TraceFromContext returns the trace and span ids carried by the context.
+func TraceFromContext(ctx context.Context) (traceID, spanID string) {
+	if trace, ok := ctx.Value(traceContextKey).(traceContext); ok {
+		traceID, spanID = trace.TraceID, trace.SpanID
+	}
+	return
+}
+
+// WithFields returns a copy of the context which carries the given fields in
+// addition to any fields already carried by the context. The fields will be
+// attached to all the lines printed via the Ctx family of functions using the
+// returned context.
+func WithFields(ctx context.Context, fields ...Field) context.Context {
+	parent := FieldsFromContext(ctx)
+
+	result := make(Fields, 0, len(parent)+len(fields))
+	result = append(result, parent...)
+	result = append(result, fields...)
+
+	return context.WithValue(ctx, fieldsContextKey, result)
+}
+
+// FieldsFromContext returns the fields carried by the context.
+func FieldsFromContext(ctx context.Context) Fields {
+	fields, _ := ctx.Value(fieldsContextKey).(Fields)
+	return fields
+}
+
+// WithLogger returns a copy of the context which carries the given logger.
+func WithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger carried by the context or the global logger if
+// the context doesn't carry one.
+func FromContext(ctx context.Context) *Logger {
+	if result, ok := ctx.Value(loggerContextKey).(*Logger); ok && result != nil {
+		return result
+	}
+	return logger
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	ring := NewRing(10)
+	logger := New(ring, NilPrinter)
+
+	ctx := WithTrace(context.Background(), "t0", "s0")
+	ctx = WithFields(ctx, F("user", "bob"))
+	ctx = WithFields(ctx, F("req", 1))
+
+	logger.KPrintCtx(ctx, "a", "x")
+	logger.KPrintwCtx(ctx, "b", "y", F("n", 2))
+	logger.KLogCtx(WithTrace(ctx, "t1", "s1"), LevelError, "a", "z")
+	logger.KPrintfCtx(context.Background(), "c", "%s", "w")
+
+	lines := ring.GetTrace("t0")
+	ExpectOrdered(t, Simplify(lines),
+		"<a> x",
+		"<b> y",
+	)
+
+	if len(lines) != 2 {
+		t.FailNow()
+	}
+
+	if exp := "user=bob req=1 n=2"; lines[1].Fields.String() != exp {
+		t.Errorf("FAIL: fields '%s' != '%s'", lines[1].Fields, exp)
+	}
+
+	if lines[0].SpanID != "s0" {
+		t.Errorf("FAIL: unexpected span '%s'", lines[0].SpanID)
+	}
+
+	lines = ring.GetTrace("t1")
+	ExpectOrdered(t, Simplify(lines), "<a> z")
+
+	if len(lines) == 1 && lines[0].Level != LevelError {
+		t.Errorf("FAIL: unexpected level '%s'", lines[0].Level)
+	}
+}
+
+func TestContext_Logger(t *testing.T) {
+	ring := NewRing(10)
+	ctx := WithLogger(context.Background(), New(ring, NilPrinter))
+
+	if FromContext(context.Background()) != logger {
+		t.Error("FAIL: expected global logger")
+	}
+
+	KPrintCtx(WithTrace(ctx, "t0", ""), "a", "x")
+	ExpectOrdered(t, Simplify(ring.GetTrace("t0")), "<a> x")
+}
@@ -3,17 +3,85 @@
 package klog
 
 import (
+	"container/list"
 	"fmt"
+	"log"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // DefaultDedupRate is used when Dedup.Rate is left empty.
 const DefaultDedupRate = 1 * time.Second
 
+// DefaultDedupFormat is used when Dedup.Format is left empty.
+const DefaultDedupFormat = "%s [%d times]"
+
+// DefaultDedupMaxEntries is used when Dedup.MaxEntries is left empty.
+const DefaultDedupMaxEntries = 4096
+
+// DedupMask is the token that replaces the matches of the user-supplied masks
+// of Dedup.
+const DedupMask = "<*>"
+
+type dedupMask struct {
+	regex *regexp.Regexp
+	token string
+}
+
+// dedupMasks are applied in order when normalization is enabled which matters
+// since most of the tokens contain numbers.
+var dedupMasks = []dedupMask{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b|\b(?:[0-9a-fA-F]{1,4}:)+:(?:[0-9a-fA-F]{1,4}:)*[0-9a-fA-F]{1,4}\b`), "<ip>"},
+	{regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+\b`), "<duration>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "<num>"},
+}
+
+// dedupKeys are the fields added to the summaries which can't be overridden by
+// the fields of the lines.
+var dedupKeys = map[string]bool{
+	"count": true, "first_ts": true, "last_ts": true, "example": true,
+}
+
 type dedupLine struct {
-	Value string
-	Count int
+	Key     string
+	Value   string
+	ID      string
+	Example string
+	Level   Level
+	TraceID string
+	SpanID  string
+	Fields  Fields
+	Count   int
+
+	First time.Time
+	Last  time.Time
+
+	// seen is the time at which the line was added in windowed mode or last
+	// seen otherwise and is used to expire the entries.
+	seen time.Time
+}
+
+type dedupPair struct {
+	Key string
+	ID  string
+}
+
+// DedupStats reports the number of lines tracked by a dedup printer.
+type DedupStats struct {
+	// Size is the number of entries currently tracked.
+	Size int `json:"size"`
+
+	// Evictions is the number of entries discarded because MaxEntries was
+	// reached.
+	Evictions uint64 `json:"evictions"`
+
+	// Expirations is the number of entries discarded because their window
+	// elapsed or because they were idle for longer than IdleTimeout.
+	Expirations uint64 `json:"expirations"`
 }
 
 // Dedup aggregates multiple consecutive identical lines into a single line with
@@ -21,16 +89,83 @@ type dedupLine struct {
 // encountered is always printed right away and only subsequent identical lines
 // are held back prior to being printed. Held back line are printed at a set
 // configurable rate.
+//
+// Summaries carry the number of held back lines along with the timestamps of
+// the first and last time the line was seen as the "count", "first_ts" and
+// "last_ts" fields and are timestamped with the last time the line was seen.
+//
+// Lines are only considered identical if both their values and their fields are
+// identical and summaries carry the fields of the held back lines. Fields which
+// collide with the fields of the summary are prefixed by ReservedFieldPrefix.
+// Summaries also carry the trace and span ids of the first held back line.
+//
+// Lines can optionally be normalized before being compared such that lines
+// which only differ in their variable parts are considered identical. Summaries
+// of normalized lines show the normalized template along with an example of the
+// original lines.
+//
+// In windowed mode, every distinct line seen for a key within the window is
+// tracked instead of only the last one such that alternating lines are also
+// aggregated. Each line has its own window which starts when the line is first
+// seen and its summary is printed at the end of its window along with the
+// timestamps of the first and last time it was seen.
+//
+// The number of entries tracked is bounded and the least recently seen entries
+// are evicted when the limit is reached. Held back lines are always printed
+// before their entry is discarded.
 type Dedup struct {
 	Chained
 
-	// Rate determines the interval at which duplicated lines are dumped.
+	// Rate determines the interval at which duplicated lines are dumped. Not
+	// used in windowed mode where lines are dumped at the end of their window.
 	Rate time.Duration
 
+	// Format is the fmt format used to print the value of summaries which
+	// receives the value and the number of times it was seen. Defaults to
+	// DefaultDedupFormat.
+	Format string
+
+	// Normalize masks the numbers, UUIDs, IPs and durations of the values before
+	// comparing them.
+	Normalize bool
+
+	// Masks are regexes whose matches are replaced by DedupMask before
+	// comparing the values.
+	Masks []string
+
+	// Window enables the windowed mode if set and is the duration for which a
+	// line is tracked after it was first seen. The window of a line is fixed and
+	// isn't extended when the line is seen again so lines that are continuously
+	// repeated are still summarized once per window.
+	Window time.Duration
+
+	// MaxEntries is the maximum number of keys tracked or, in windowed mode,
+	// the maximum number of lines tracked. The least recently seen keys or the
+	// oldest lines are summarized and discarded when the limit is reached.
+	// Defaults to DefaultDedupMaxEntries.
+	MaxEntries int
+
+	// IdleTimeout discards the keys which weren't seen for the given duration
+	// if set. Has no effect in windowed mode where lines expire at the end of
+	// their window.
+	IdleTimeout time.Duration
+
 	initialize sync.Once
 
-	lines  map[string]*dedupLine
+	masks []dedupMask
+
+	lines  map[string]*list.Element
+	window map[dedupPair]*list.Element
+	order  *list.List
+
+	size        atomic.Int64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+
 	printC chan *Line
+	flushC chan chan struct{}
+	closeC chan struct{}
+	doneC  chan struct{}
 }
 
 // NewDedup creates a new Dedup printer.
@@ -47,42 +182,221 @@ func (dedup *Dedup) init() {
 		dedup.Rate = DefaultDedupRate
 	}
 
-	dedup.lines = make(map[string]*dedupLine)
+	if len(dedup.Format) == 0 {
+		dedup.Format = DefaultDedupFormat
+	}
+
+	if dedup.MaxEntries == 0 {
+		dedup.MaxEntries = DefaultDedupMaxEntries
+	}
+
+	for _, expr := range dedup.Masks {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			log.Panicf("invalid dedup mask: %s", err)
+		}
+		dedup.masks = append(dedup.masks, dedupMask{regex, DedupMask})
+	}
+
+	if dedup.Normalize {
+		dedup.masks = append(dedup.masks, dedupMasks...)
+	}
+
+	dedup.lines = make(map[string]*list.Element)
+	dedup.window = make(map[dedupPair]*list.Element)
+	dedup.order = list.New()
 	dedup.printC = make(chan *Line, DefaultBufferC)
+	dedup.flushC = make(chan chan struct{})
+	dedup.closeC = make(chan struct{})
+	dedup.doneC = make(chan struct{})
 
 	go dedup.run()
 }
 
 // Print checks the line checking for duplicates. If the line was never seen
 // before it is passed to the chained printer right away otherwise it is held
-// back and counted.
+// back and counted. Lines printed after the printer is closed are discarded.
 func (dedup *Dedup) Print(line *Line) {
 	dedup.Init()
-	dedup.printC <- line
+
+	select {
+	case dedup.printC <- line:
+	case <-dedup.doneC:
+	}
+}
+
+// Stats returns the number of entries tracked along with the number of entries
+// that were evicted or expired.
+func (dedup *Dedup) Stats() DedupStats {
+	dedup.Init()
+
+	return DedupStats{
+		Size:        int(dedup.size.Load()),
+		Evictions:   dedup.evictions.Load(),
+		Expirations: dedup.expirations.Load(),
+	}
+}
+
+// Flush processes all pending lines and prints all held back lines before
+// flushing the rest of the pipeline.
+func (dedup *Dedup) Flush() error {
+	dedup.Init()
+
+	resultC := make(chan struct{})
+
+	select {
+	case dedup.flushC <- resultC:
+		<-resultC
+	case <-dedup.doneC:
+	}
+
+	return dedup.FlushNext()
+}
+
+// Close prints all pending and held back lines and stops the background
+// goroutine before closing the rest of the pipeline.
+func (dedup *Dedup) Close() error {
+	dedup.Init()
+
+	select {
+	case dedup.closeC <- struct{}{}:
+		<-dedup.doneC
+	case <-dedup.doneC:
+	}
+
+	return dedup.CloseNext()
 }
 
 func (dedup *Dedup) print(line *Line) {
-	counter, ok := dedup.lines[line.Key]
-	if !ok {
-		counter = new(dedupLine)
-		dedup.lines[line.Key] = counter
+	if dedup.Window > 0 {
+		dedup.printWindow(line)
+		return
 	}
 
-	if counter.Value != line.Value {
+	var counter *dedupLine
+
+	if elem, ok := dedup.lines[line.Key]; ok {
+		dedup.order.MoveToBack(elem)
+		counter = elem.Value.(*dedupLine)
+
+	} else {
+		dedup.reserve()
+		counter = &dedupLine{Key: line.Key}
+		dedup.lines[line.Key] = dedup.order.PushBack(counter)
+		dedup.size.Store(int64(dedup.order.Len()))
+	}
+
+	counter.seen = time.Now()
+	value, id := dedup.identify(line)
+
+	if counter.ID != id {
 		dedup.send(line.Key, counter)
 
 		dedup.PrintNext(line)
 		counter.Count = 0
-		counter.Value = line.Value
+		counter.Value = value
+		counter.ID = id
+		counter.Level = line.Level
 
 	} else {
-		counter.Count++
+		counter.hold(line)
+	}
+}
+
+// hold counts a held back line. The first held back line is kept as an example
+// of the lines aggregated by the summary and its timestamp is used as the start
+// of the summary such that the count and the timestamps always describe the
+// same lines, even after a flush.
+func (counter *dedupLine) hold(line *Line) {
+	if counter.Count == 0 {
+		counter.First = line.Timestamp
+		counter.Example = line.Value
+		counter.TraceID = line.TraceID
+		counter.SpanID = line.SpanID
+		counter.Fields = line.Fields
 	}
+	counter.Count++
+	counter.Last = line.Timestamp
+}
+
+func (dedup *Dedup) printWindow(line *Line) {
+	value, id := dedup.identify(line)
+	pair := dedupPair{line.Key, id}
+
+	if elem, ok := dedup.window[pair]; ok {
+		elem.Value.(*dedupLine).hold(line)
+		return
+	}
+
+	dedup.reserve()
+	dedup.PrintNext(line)
+
+	dedup.window[pair] = dedup.order.PushBack(&dedupLine{
+		Key:   line.Key,
+		Value: value,
+		ID:    id,
+		Level: line.Level,
+		seen:  time.Now(),
+	})
+	dedup.size.Store(int64(dedup.order.Len()))
+}
+
+// reserve makes room for a new entry by evicting the least recently seen
+// entries until there's fewer than MaxEntries entries.
+func (dedup *Dedup) reserve() {
+	for dedup.order.Len() >= dedup.MaxEntries {
+		dedup.evict(dedup.order.Front())
+		dedup.evictions.Add(1)
+	}
+}
+
+// expire summarizes and discards the entries which were seen more than ttl ago.
+// Entries are kept in the order in which they were added or last seen so only
+// the front of the list needs to be checked.
+func (dedup *Dedup) expire(now time.Time, ttl time.Duration) {
+	for elem := dedup.order.Front(); elem != nil; elem = dedup.order.Front() {
+		if elem.Value.(*dedupLine).seen.Add(ttl).After(now) {
+			break
+		}
+		dedup.evict(elem)
+		dedup.expirations.Add(1)
+	}
+	dedup.size.Store(int64(dedup.order.Len()))
+}
+
+func (dedup *Dedup) evict(elem *list.Element) {
+	counter := dedup.order.Remove(elem).(*dedupLine)
+
+	if dedup.Window > 0 {
+		delete(dedup.window, dedupPair{counter.Key, counter.ID})
+	} else {
+		delete(dedup.lines, counter.Key)
+	}
+
+	dedup.send(counter.Key, counter)
+}
+
+// identify returns the normalized value of the line along with the identity
+// of the line used to compare it to other lines which includes its fields.
+func (dedup *Dedup) identify(line *Line) (value, id string) {
+	value = dedup.normalize(line.Value)
+	if len(line.Fields) == 0 {
+		return value, value
+	}
+	return value, value + "\x00" + dedup.normalize(line.Fields.String())
+}
+
+func (dedup *Dedup) normalize(value string) string {
+	for _, mask := range dedup.masks {
+		value = mask.regex.ReplaceAllLiteralString(value, mask.token)
+	}
+	return value
 }
 
 func (dedup *Dedup) flush() {
-	for key, counter := range dedup.lines {
-		dedup.send(key, counter)
+	for elem := dedup.order.Front(); elem != nil; elem = elem.Next() {
+		counter := elem.Value.(*dedupLine)
+		dedup.send(counter.Key, counter)
 		counter.Count = 0
 	}
 }
@@ -92,27 +406,91 @@ func (dedup *Dedup) send(key string, counter *dedupLine) {
 		return
 	}
 
-	var value string
+	line := &Line{
+		Timestamp: counter.Last,
+		Key:       key,
+		Value:     counter.Example,
+		Level:     counter.Level,
+		TraceID:   counter.TraceID,
+		SpanID:    counter.SpanID,
+		Fields:    counter.Fields,
+	}
 
-	if counter.Count == 1 {
-		value = counter.Value
-	} else {
-		value = fmt.Sprintf("%s [%d times]", counter.Value, counter.Count)
+	if counter.Count > 1 {
+		line.Value = fmt.Sprintf(dedup.Format, counter.Value, counter.Count)
+
+		fields := counter.Fields.escape(dedupKeys)
+		n := len(fields)
+		line.Fields = append(fields[:n:n],
+			F("count", counter.Count),
+			F("first_ts", counter.First.Round(0)),
+			F("last_ts", counter.Last.Round(0)))
+
+		if len(dedup.masks) > 0 {
+			line.Fields = append(line.Fields, F("example", counter.Example))
+		}
 	}
 
-	dedup.PrintNext(&Line{Timestamp: time.Now(), Key: key, Value: value})
+	dedup.PrintNext(line)
+}
+
+func (dedup *Dedup) drain() {
+	for {
+		select {
+		case line := <-dedup.printC:
+			dedup.print(line)
+		default:
+			dedup.flush()
+			return
+		}
+	}
 }
 
 func (dedup *Dedup) run() {
-	tickC := time.Tick(dedup.Rate)
+	defer close(dedup.doneC)
+
+	ticker := time.NewTicker(dedup.Rate)
+	defer ticker.Stop()
+
+	// In windowed mode, the timer is armed for the end of the window of the
+	// oldest line such that summaries are printed as soon as the window ends.
+	timer := time.NewTimer(dedup.Window)
+	defer timer.Stop()
+
+	if dedup.Window == 0 {
+		timer.Stop()
+	}
 
 	for {
 		select {
 		case line := <-dedup.printC:
 			dedup.print(line)
 
-		case <-tickC:
-			dedup.flush()
+		case <-ticker.C:
+			if dedup.Window == 0 {
+				dedup.flush()
+				if dedup.IdleTimeout > 0 {
+					dedup.expire(time.Now(), dedup.IdleTimeout)
+				}
+			}
+
+		case <-timer.C:
+			now := time.Now()
+			dedup.expire(now, dedup.Window)
+
+			next := dedup.Window
+			if front := dedup.order.Front(); front != nil {
+				next = front.Value.(*dedupLine).seen.Add(dedup.Window).Sub(now)
+			}
+			timer.Reset(next)
+
+		case c := <-dedup.flushC:
+			dedup.drain()
+			close(c)
+
+		case <-dedup.closeC:
+			dedup.drain()
+			return
 		}
 	}
 }
@@ -0,0 +1,37 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"github.com/datacratic/gorest/rest"
+)
+
+// DedupREST provides the REST interface for the Dedup chained printer.
+type DedupREST struct {
+	*Dedup
+
+	// PathPrefix will be pre-pended to all the REST paths. Defaults to
+	// DefaultPathREST.
+	PathPrefix string
+}
+
+// NewDedupREST creates a new REST enabled Dedup chained printer at the
+// specified path.
+func NewDedupREST(path string) *DedupREST {
+	dedup := &DedupREST{Dedup: NewDedup(), PathPrefix: path}
+	rest.AddService(dedup)
+	return dedup
+}
+
+// RESTRoutes returns the set of gorest routes used to monitor the Dedup chained
+// printer.
+func (dedup *DedupREST) RESTRoutes() rest.Routes {
+	prefix := dedup.PathPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultPathREST + "/dedup"
+	}
+
+	return []*rest.Route{
+		rest.NewRoute(prefix+"/stats", "GET", dedup.Stats),
+	}
+}
@@ -3,6 +3,7 @@
 package klog
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -123,3 +124,319 @@ func BenchmarkDedup_NoDup(b *testing.B) {
 		dedup.Print(l1)
 	}
 }
+
+func TestDedup_Normalize(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour, Normalize: true, Masks: []string{`user=\w+`}}
+	dedup.Chain(out)
+
+	dedup.Print(L("a", "request 12 failed after 1.5s"))
+	dedup.Print(L("a", "request 13 failed after 300ms"))
+	dedup.Print(L("a", "request 14 failed after 2m30s"))
+
+	dedup.Print(L("b", "connect to 10.0.0.1:80 failed"))
+	dedup.Print(L("b", "connect to 10.0.0.2:8080 failed"))
+
+	dedup.Print(L("c", "job 123e4567-e89b-12d3-a456-426614174000 done user=bob"))
+	dedup.Print(L("c", "job 00000000-0000-0000-0000-000000000000 done user=alice"))
+	dedup.Print(L("c", "job 00000000-0000-0000-0000-000000000000 failed"))
+
+	dedup.Flush()
+
+	out.ExpectOrdered(
+		"<a> request 12 failed after 1.5s",
+		"<b> connect to 10.0.0.1:80 failed",
+		"<c> job 123e4567-e89b-12d3-a456-426614174000 done user=bob",
+		"<c> job 00000000-0000-0000-0000-000000000000 done user=alice",
+		"<c> job 00000000-0000-0000-0000-000000000000 failed",
+	)
+
+	out.ExpectUnordered(
+		"<a> request <num> failed after <duration> [2 times]",
+		"<b> connect to 10.0.0.2:8080 failed",
+	)
+
+	dedup.Print(L("a", "request 15 failed after 1s"))
+	dedup.Print(L("a", "request 16 failed after 1s"))
+	dedup.Close()
+
+	lines := out.GetLines(1)
+	if len(lines) != 1 || lines[0].Value != "request <num> failed after <duration> [2 times]" {
+		t.Fatalf("FAIL: unexpected lines %v", lines)
+	}
+
+	if fields := lines[0].Fields; len(fields) != 4 || fields[3].Key != "example" || fields[3].Value != "request 15 failed after 1s" {
+		t.Errorf("FAIL: unexpected fields %v", fields)
+	}
+}
+
+func TestDedup_NormalizeTokens(t *testing.T) {
+	dedup := Dedup{Normalize: true}
+	dedup.Init()
+	defer dedup.Close()
+
+	tests := map[string]string{
+		"id 123e4567-E89B-12d3-a456-426614174000": "id <uuid>",
+		"from 192.168.1.1 and [fe80::1]":          "from <ip> and [<ip>]",
+		"took 10µs then 1h2m3.5s":                 "took <duration> then <duration>",
+		"retry 3 of 5 at 0.75":                    "retry <num> of <num> at <num>",
+		"client42 sent 5min ago":                  "client<num> sent <num>min ago",
+	}
+
+	for value, exp := range tests {
+		if result := dedup.normalize(value); result != exp {
+			t.Errorf("FAIL: normalize('%s') -> '%s' != '%s'", value, result, exp)
+		}
+	}
+}
+
+func TestDedup_Window(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour, Window: 20 * time.Millisecond}
+	dedup.Chain(out)
+
+	first := time.Now()
+	for i := 0; i < 3; i++ {
+		dedup.Print(&Line{Timestamp: first.Add(time.Duration(i) * time.Second), Key: "a", Value: "x"})
+		dedup.Print(&Line{Timestamp: first.Add(time.Duration(i) * time.Second), Key: "a", Value: "y"})
+	}
+
+	out.ExpectOrdered("<a> x", "<a> y")
+
+	time.Sleep(50 * time.Millisecond)
+
+	lines := out.GetLines(2)
+	ExpectOrdered(t, Simplify(lines), "<a> x [2 times]", "<a> y [2 times]")
+
+	if len(lines) == 2 {
+		fields := lines[0].Fields
+		if len(fields) != 3 || fields[1].Key != "first_ts" || fields[2].Key != "last_ts" {
+			t.Fatalf("FAIL: unexpected fields %v", fields)
+		}
+
+		if !fields[1].Value.(time.Time).Equal(first.Add(time.Second)) || !fields[2].Value.(time.Time).Equal(first.Add(2*time.Second)) {
+			t.Errorf("FAIL: unexpected timestamps %v", fields)
+		}
+	}
+
+	// The window has elapsed so the lines are printed again.
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Close()
+
+	out.ExpectOrdered("<a> x", "<a> x")
+}
+
+func TestDedup_WindowMaxEntries(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour, Window: time.Hour, MaxEntries: 2}
+	dedup.Chain(out)
+
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("b", "x"))
+	dedup.Print(L("a", "y"))
+	dedup.Print(L("b", "x"))
+	dedup.Flush()
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<b> x",
+		"<a> x [2 times]",
+		"<a> y",
+		"<b> x",
+	)
+
+	dedup.Print(L("b", "x"))
+	dedup.Close()
+
+	out.ExpectOrdered("<b> x")
+}
+
+func TestDedup_MaxEntries(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour, MaxEntries: 2}
+	dedup.Chain(out)
+
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("b", "x"))
+	dedup.Print(L("b", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("c", "x"))
+	dedup.Flush()
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<b> x",
+		"<b> x",
+		"<c> x",
+		"<a> x [2 times]",
+	)
+
+	if stats := dedup.Stats(); stats != (DedupStats{Size: 2, Evictions: 1}) {
+		t.Errorf("FAIL: unexpected stats %+v", stats)
+	}
+
+	dedup.Print(L("b", "x"))
+	dedup.Close()
+
+	out.ExpectOrdered("<b> x")
+}
+
+func TestDedup_IdleTimeout(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: 10 * time.Millisecond, IdleTimeout: 20 * time.Millisecond}
+	dedup.Chain(out)
+
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("a", "x"))
+	dedup.Print(L("b", "x"))
+	dedup.Flush()
+
+	out.ExpectUnordered("<a> x", "<a> x", "<b> x")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if stats := dedup.Stats(); stats != (DedupStats{Expirations: 2}) {
+		t.Errorf("FAIL: unexpected stats %+v", stats)
+	}
+
+	dedup.Print(L("a", "x"))
+	dedup.Close()
+
+	out.ExpectOrdered("<a> x")
+}
+
+func TestDedup_Summary(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour, Format: "%[1]s (x%[2]d)"}
+	dedup.Chain(out)
+
+	ts := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		dedup.Print(&Line{
+			Timestamp: ts.Add(time.Duration(i) * time.Minute),
+			Key:       "a",
+			Value:     "x",
+			Level:     LevelWarn,
+			Fields:    Fields{F("id", 1)},
+		})
+	}
+	dedup.Close()
+
+	lines := out.GetLines(2)
+	ExpectOrdered(t, Simplify(lines), "<a> x", "<a> x (x3)")
+
+	if len(lines) != 2 {
+		return
+	}
+
+	summary := lines[1]
+
+	if !summary.Timestamp.Equal(ts.Add(3*time.Minute)) || summary.Level != LevelWarn {
+		t.Errorf("FAIL: unexpected summary %v", summary)
+	}
+
+	exp := `{"ts":"2014-01-01T00:03:00Z","key":"a","val":"x (x3)","level":"warn","id":1,"count":3,` +
+		`"first_ts":"2014-01-01T00:01:00Z","last_ts":"2014-01-01T00:03:00Z"}`
+	if js := string(FormatJSON(summary)); strings.TrimSpace(js) != exp {
+		t.Errorf("FAIL: %s != %s", js, exp)
+	}
+}
+
+func TestDedup_SummaryFlush(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour}
+	dedup.Chain(out)
+
+	ts := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	printAt := func(minutes ...int) {
+		for _, minute := range minutes {
+			dedup.Print(&Line{Timestamp: ts.Add(time.Duration(minute) * time.Minute), Key: "a", Value: "x"})
+		}
+	}
+
+	printAt(0, 1, 2)
+	dedup.Flush()
+	printAt(60, 61)
+	dedup.Close()
+
+	lines := out.GetLines(3)
+	ExpectOrdered(t, Simplify(lines), "<a> x", "<a> x [2 times]", "<a> x [2 times]")
+
+	if len(lines) != 3 {
+		return
+	}
+
+	for i, exp := range [][2]int{{1, 2}, {60, 61}} {
+		fields := lines[i+1].Fields
+		if len(fields) != 3 || fields[0] != F("count", 2) {
+			t.Errorf("FAIL: unexpected summary fields '%s'", fields)
+			continue
+		}
+
+		first, last := ts.Add(time.Duration(exp[0])*time.Minute), ts.Add(time.Duration(exp[1])*time.Minute)
+		if fields[1] != F("first_ts", first) || fields[2] != F("last_ts", last) {
+			t.Errorf("FAIL: unexpected summary timestamps '%s'", fields)
+		}
+	}
+}
+
+func TestDedup_Fields(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour}
+	dedup.Chain(out)
+
+	line := func(fields ...Field) *Line {
+		return &Line{Timestamp: time.Now(), Key: "a", Value: "x", Fields: fields}
+	}
+
+	dedup.Print(line(F("id", 1)))
+	dedup.Print(line(F("id", 2)))
+	dedup.Print(line(F("id", 2), F("count", 10)))
+	dedup.Print(line(F("id", 2), F("count", 10)))
+	dedup.Print(line(F("id", 2), F("count", 10)))
+	dedup.Close()
+
+	lines := out.GetLines(4)
+	if len(lines) != 4 {
+		t.Fatalf("FAIL: expected 4 lines got %d", len(lines))
+	}
+
+	for i, exp := range []string{"id=1", "id=2", "id=2 count=10"} {
+		if fields := lines[i].Fields.String(); fields != exp {
+			t.Errorf("FAIL: %d: %s != %s", i, fields, exp)
+		}
+	}
+
+	summary := lines[3].Fields
+	if len(summary) != 5 || summary[0] != F("id", 2) || summary[1] != F("field.count", 10) || summary[2] != F("count", 2) {
+		t.Errorf("FAIL: unexpected summary fields '%s'", summary)
+	}
+}
+
+func TestDedup_Trace(t *testing.T) {
+	out := &TestPrinter{T: t}
+	dedup := Dedup{Rate: time.Hour}
+	dedup.Chain(out)
+
+	line := func(trace, span string) *Line {
+		return &Line{Timestamp: time.Now(), Key: "a", Value: "x", TraceID: trace, SpanID: span}
+	}
+
+	dedup.Print(line("t1", "s1"))
+	dedup.Print(line("t2", "s2"))
+	dedup.Print(line("t3", "s3"))
+	dedup.Close()
+
+	lines := out.GetLines(2)
+	if len(lines) != 2 {
+		t.Fatalf("FAIL: expected 2 lines got %d", len(lines))
+	}
+
+	if lines[1].TraceID != "t2" || lines[1].SpanID != "s2" {
+		t.Errorf("FAIL: unexpected summary trace '%s' and span '%s'", lines[1].TraceID, lines[1].SpanID)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultDemuxMaxOpen is used when Demux.MaxOpen is left empty.
+const DefaultDemuxMaxOpen = 64
+
+// DefaultDemuxExt is used when Demux.Ext is left empty.
+const DefaultDemuxExt = ".log"
+
+type demuxFile struct {
+	Path string
+	File *File
+
+	// users tracks the lines being printed to the file outside of the lock of
+	// the demux such that the file is only closed once they're done.
+	users sync.WaitGroup
+}
+
+func (file *demuxFile) close() error {
+	file.users.Wait()
+	return file.File.Close()
+}
+
+// Demux routes each line to a File printer based on the key of the line. The
+// segments of the key are used as the path of the file relative to Dir such
+// that the key "db.query.slow" with a depth of 2 is written to "db/query.log".
+// Only a bounded number of files are kept open at any given time and the least
+// recently used files are closed as needed.
+type Demux struct {
+	// Dir is the directory where the files will be created.
+	Dir string
+
+	// Depth is the number of key segments used to select the file. If 0 then the
+	// full key is used.
+	Depth int
+
+	// Ext is appended to the path of all the files. Defaults to
+	// DefaultDemuxExt.
+	Ext string
+
+	// MaxOpen is the maximum number of files to keep open. Defaults to
+	// DefaultDemuxMaxOpen.
+	MaxOpen int
+
+	// NewFile is used to create the File printer for a given path which can be
+	// used to configure rotation. Defaults to NewFile.
+	NewFile func(path string) *File
+
+	initialize sync.Once
+
+	mutex sync.Mutex
+	files map[string]*list.Element
+	lru   *list.List
+}
+
+// NewDemux creates a new Demux printer which creates files in the given
+// directory using the given number of key segments.
+func NewDemux(dir string, depth int) *Demux { return &Demux{Dir: dir, Depth: depth} }
+
+// Init initializes the object. Calling this is optional since the object will
+// lazily initialize itself when needed.
+func (demux *Demux) Init() {
+	demux.initialize.Do(demux.init)
+}
+
+func (demux *Demux) init() {
+	if demux.MaxOpen == 0 {
+		demux.MaxOpen = DefaultDemuxMaxOpen
+	}
+
+	if len(demux.Ext) == 0 {
+		demux.Ext = DefaultDemuxExt
+	}
+
+	if demux.NewFile == nil {
+		demux.NewFile = NewFile
+	}
+
+	demux.files = make(map[string]*list.Element)
+	demux.lru = list.New()
+}
+
+// Path returns the path of the file associated with the given key.
+func (demux *Demux) Path(key string) string {
+	demux.Init()
+
+	segments := strings.Split(key, ".")
+	if demux.Depth > 0 && len(segments) > demux.Depth {
+		segments = segments[:demux.Depth]
+	}
+
+	for i, segment := range segments {
+		segment = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' {
+				return '_'
+			}
+			return r
+		}, segment)
+
+		if len(segment) == 0 || segment == ".." {
+			segment = "_"
+		}
+
+		segments[i] = segment
+	}
+
+	return filepath.Join(demux.Dir, filepath.Join(segments...)) + demux.Ext
+}
+
+// Print forwards the line to the file associated with its key. Files are
+// written and evicted files are closed outside of the lock of the demux so that
+// a slow file doesn't block the other files.
+func (demux *Demux) Print(line *Line) {
+	path := demux.Path(line.Key)
+
+	demux.mutex.Lock()
+	file, evicted := demux.get(path)
+	demux.mutex.Unlock()
+
+	file.File.Print(line)
+	file.users.Done()
+
+	for _, file := range evicted {
+		file.close()
+	}
+}
+
+// get returns the file associated with the path along with the files evicted
+// to make room for it. The returned file must be released by calling Done on
+// its users.
+func (demux *Demux) get(path string) (file *demuxFile, evicted []*demuxFile) {
+	if elem, ok := demux.files[path]; ok {
+		demux.lru.MoveToFront(elem)
+		file = elem.Value.(*demuxFile)
+
+	} else {
+		file = &demuxFile{Path: path, File: demux.NewFile(path)}
+		demux.files[path] = demux.lru.PushFront(file)
+	}
+
+	file.users.Add(1)
+
+	for demux.lru.Len() > demux.MaxOpen {
+		elem := demux.lru.Back()
+		evicted = append(evicted, demux.lru.Remove(elem).(*demuxFile))
+		delete(demux.files, evicted[len(evicted)-1].Path)
+	}
+
+	return
+}
+
+// Flush flushes all the open files.
+func (demux *Demux) Flush() (err error) {
+	demux.Init()
+
+	demux.mutex.Lock()
+	defer demux.mutex.Unlock()
+
+	for elem := demux.lru.Front(); elem != nil; elem = elem.Next() {
+		if flushErr := elem.Value.(*demuxFile).File.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+
+	return
+}
+
+// Close closes all the open files. Files will be reopened if new lines are
+// printed.
+func (demux *Demux) Close() (err error) {
+	demux.Init()
+
+	demux.mutex.Lock()
+	lru := demux.lru
+	demux.files = make(map[string]*list.Element)
+	demux.lru = list.New()
+	demux.mutex.Unlock()
+
+	for elem := lru.Front(); elem != nil; elem = elem.Next() {
+		if closeErr := elem.Value.(*demuxFile).close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDemux(t *testing.T) {
+	dir := t.TempDir()
+
+	demux := NewDemux(dir, 2)
+	demux.MaxOpen = 2
+	demux.NewFile = func(path string) *File {
+		file := NewFile(path)
+		file.Format = LineFormat
+		return file
+	}
+
+	demux.Print(L("db.query.slow", "a"))
+	demux.Print(L("db.query.fast", "b"))
+	demux.Print(L("db", "c"))
+	demux.Print(L("http.server", "d"))
+	demux.Print(L("db.query", "e"))
+	demux.Print(L("../x", "f"))
+
+	if n := demux.lru.Len(); n != 2 {
+		t.Errorf("FAIL: expected 2 open files got %d", n)
+	}
+
+	if err := demux.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(path, exp string) {
+		if body := ReadFile(t, filepath.Join(dir, path)); body != exp {
+			t.Errorf("FAIL: %s: '%s' != '%s'", path, body, exp)
+		}
+	}
+
+	expect("db/query.log", "a\nb\ne\n")
+	expect("db.log", "c\n")
+	expect("http/server.log", "d\n")
+	expect("_/_.log", "f\n")
+}
+
+func TestDemux_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	demux := NewDemux(dir, 0)
+	demux.MaxOpen = 2
+	demux.NewFile = func(path string) *File {
+		file := NewFile(path)
+		file.Format = LineFormat
+		return file
+	}
+
+	var wait sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				demux.Print(L(Keyf("k%d", j%5), "x"))
+			}
+		}(i)
+	}
+
+	wait.Wait()
+
+	if err := demux.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, Keyf("k%d.log", i))
+		if n := strings.Count(ReadFile(t, path), "x\n"); n != 80 {
+			t.Errorf("FAIL: %s: expected 80 lines got %d", path, n)
+		}
+	}
+}
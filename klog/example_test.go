@@ -118,3 +118,28 @@ func Example_Pipeline() {
 	// <test.info> world
 	// @cee: {"ts":"0001-01-01T00:00:00Z","key":"test","val":"structured","level":"error"}
 }
+
+// Lines can be tagged with a severity level which can then be used to filter
+// the log stream.
+func Example_levels() {
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+	log.SetPrefix("klog ")
+
+	// LevelFilter discards all the lines under a given threshold. Legacy mode
+	// derives the level of lines without one from their key suffix so that
+	// keys like "test.debug" keep working.
+	filter := klog.NewLevelFilter(klog.LevelInfo)
+	filter.Legacy = true
+
+	klog.SetPrinter(klog.Chain(filter, klog.DefaultPrinter))
+
+	klog.KDebugf("test", "%s", "hidden")
+	klog.KInfof("test", "%s", "hello")
+	klog.KPrint("test.debug", "hidden")
+	klog.KErrorf("test", "%s", "world")
+
+	// Output:
+	// klog <test> [info] hello
+	// klog <test> [error] world
+}
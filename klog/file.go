@@ -0,0 +1,393 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultFileFlushRate is used when File.FlushRate is left empty.
+const DefaultFileFlushRate = 1 * time.Second
+
+// FileRetryDelay is the initial delay before trying to open the file again
+// after a failure. The delay doubles after every failure up to
+// FileMaxRetryDelay.
+const FileRetryDelay = 1 * time.Second
+
+// FileMaxRetryDelay is the maximum delay between two attempts at opening the
+// file.
+const FileMaxRetryDelay = 1 * time.Minute
+
+// FileBackupFormat is the time format appended to the path of rotated files.
+// The format sorts lexicographically which is used to determine which backups
+// are the oldest.
+const FileBackupFormat = "2006-01-02T15-04-05.000"
+
+// File writes all lines to a buffered file which can be rotated based on its
+// size and its age. Rotated files are renamed by appending the time of the
+// rotation to their path and can optionally be compressed. Buffered lines are
+// periodically flushed to the file at a configurable rate.
+type File struct {
+	// Path is the path of the file to write to.
+	Path string
+
+	// Format is used to format the lines. Defaults to FormatText.
+	Format Formatter
+
+	// MaxSize is the size in bytes after which the file is rotated. If 0 then
+	// the file is never rotated based on its size.
+	MaxSize int64
+
+	// MaxAge is the duration after which the file is rotated. If 0 then the
+	// file is never rotated based on its age.
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files to retain. If 0 then all the
+	// rotated files are retained.
+	MaxBackups int
+
+	// Compress indicates whether rotated files should be gzipped.
+	Compress bool
+
+	// ReopenOnHUP indicates whether the file should be reopened when the process
+	// receives a SIGHUP which is useful when rotation is handled by an external
+	// tool like logrotate.
+	ReopenOnHUP bool
+
+	// FlushRate determines the interval at which buffered lines are flushed to
+	// the file.
+	FlushRate time.Duration
+
+	initialize sync.Once
+
+	mutex   sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	opened  time.Time
+	rotated time.Time
+	closed  bool
+	retry   time.Time
+	delay   time.Duration
+
+	backups sync.Mutex
+	wait    sync.WaitGroup
+
+	stopC chan struct{}
+	doneC chan struct{}
+}
+
+// NewFile creates a new File printer which writes to the given path.
+func NewFile(path string) *File { return &File{Path: path} }
+
+// Init initializes the object. Calling this is optional since the object will
+// lazily initialize itself when needed.
+func (file *File) Init() {
+	file.initialize.Do(file.init)
+}
+
+func (file *File) init() {
+	if file.FlushRate == 0 {
+		file.FlushRate = DefaultFileFlushRate
+	}
+
+	if file.Format == nil {
+		file.Format = FormatText
+	}
+
+	file.stopC = make(chan struct{})
+	file.doneC = make(chan struct{})
+
+	go file.run()
+}
+
+// Print formats and writes the line to the file, rotating the file if needed.
+// Lines are dropped if the file can't be opened in which case the file isn't
+// opened again until the retry delay has elapsed.
+func (file *File) Print(line *Line) {
+	file.Init()
+
+	buffer := file.Format(line)
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.closed {
+		return
+	}
+
+	if file.file != nil && file.shouldRotate(len(buffer)) {
+		file.rotate()
+	}
+
+	if file.file == nil && (time.Now().Before(file.retry) || !file.open()) {
+		return
+	}
+
+	n, _ := file.writer.Write(buffer)
+	file.size += int64(n)
+}
+
+// Flush writes any buffered lines to the file.
+func (file *File) Flush() error {
+	file.Init()
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.file == nil {
+		return nil
+	}
+	return file.writer.Flush()
+}
+
+// Reopen flushes and closes the current file before reopening the file at
+// Path. Used to pick up a new file after the current file was moved by an
+// external tool.
+func (file *File) Reopen() error {
+	file.Init()
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.closed {
+		return nil
+	}
+
+	err := file.close()
+	file.open()
+	return err
+}
+
+// Close flushes and closes the file and waits for the compression of any
+// rotated files to complete. Lines printed after Close are dropped.
+func (file *File) Close() error {
+	file.Init()
+
+	file.mutex.Lock()
+
+	if file.closed {
+		file.mutex.Unlock()
+		return nil
+	}
+
+	file.closed = true
+	err := file.close()
+	file.mutex.Unlock()
+
+	close(file.stopC)
+	<-file.doneC
+
+	file.wait.Wait()
+	return err
+}
+
+func (file *File) shouldRotate(n int) bool {
+	if file.MaxSize > 0 && file.size > 0 && file.size+int64(n) > file.MaxSize {
+		return true
+	}
+
+	if file.MaxAge > 0 && time.Since(file.opened) >= file.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+func (file *File) open() bool {
+	if dir := filepath.Dir(file.Path); len(dir) > 0 {
+		os.MkdirAll(dir, 0755)
+	}
+
+	fd, err := os.OpenFile(file.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		if file.delay == 0 {
+			log.Printf("klog: unable to open file '%s': %s", file.Path, err)
+			file.delay = FileRetryDelay
+
+		} else {
+			file.delay *= 2
+			if file.delay > FileMaxRetryDelay {
+				file.delay = FileMaxRetryDelay
+			}
+		}
+		file.retry = time.Now().Add(file.delay)
+		return false
+	}
+
+	file.size = 0
+	if stat, err := fd.Stat(); err == nil {
+		file.size = stat.Size()
+	}
+
+	file.file = fd
+	file.writer = bufio.NewWriter(fd)
+	file.opened = time.Now()
+	file.retry = time.Time{}
+	file.delay = 0
+
+	return true
+}
+
+func (file *File) close() error {
+	if file.file == nil {
+		return nil
+	}
+
+	err := file.writer.Flush()
+	if closeErr := file.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	file.file = nil
+	file.writer = nil
+
+	return err
+}
+
+func (file *File) rotate() {
+	if err := file.close(); err != nil {
+		log.Printf("klog: unable to close file '%s': %s", file.Path, err)
+	}
+
+	// Backups are ordered by their name so make sure that two rotations within
+	// the same millisecond don't end up with the same name.
+	rotated := time.Now().Truncate(time.Millisecond)
+	if !rotated.After(file.rotated) {
+		rotated = file.rotated.Add(time.Millisecond)
+	}
+	file.rotated = rotated
+
+	backup := file.Path + "." + rotated.Format(FileBackupFormat)
+	if err := os.Rename(file.Path, backup); err != nil {
+		log.Printf("klog: unable to rotate file '%s': %s", file.Path, err)
+		return
+	}
+
+	file.wait.Add(1)
+	go file.processBackups(backup)
+}
+
+func (file *File) processBackups(backup string) {
+	defer file.wait.Done()
+
+	file.backups.Lock()
+	defer file.backups.Unlock()
+
+	if file.Compress {
+		if err := compressFile(backup); err != nil {
+			log.Printf("klog: unable to compress file '%s': %s", backup, err)
+		}
+	}
+
+	if file.MaxBackups > 0 {
+		backups := file.listBackups()
+
+		for len(backups) > file.MaxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				log.Printf("klog: unable to remove file '%s': %s", backups[0], err)
+			}
+			backups = backups[1:]
+		}
+	}
+}
+
+// listBackups lists the directory of the file instead of using a glob since the
+// path may contain glob meta-characters.
+func (file *File) listBackups() []string {
+	dir, base := filepath.Split(file.Path)
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+
+	var backups []string
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), base+".") {
+			continue
+		}
+
+		suffix := strings.TrimSuffix(entry.Name()[len(base)+1:], ".gz")
+		if _, err := time.Parse(FileBackupFormat, suffix); err == nil {
+			backups = append(backups, dir+entry.Name())
+		}
+	}
+
+	sort.Strings(backups)
+	return backups
+}
+
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+
+	writer := gzip.NewWriter(dst)
+
+	if _, err = io.Copy(writer, src); err == nil {
+		err = writer.Close()
+	}
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return
+	}
+
+	return os.Remove(path)
+}
+
+func (file *File) run() {
+	defer close(file.doneC)
+
+	tickC := time.NewTicker(file.FlushRate)
+	defer tickC.Stop()
+
+	var hupC chan os.Signal
+
+	if file.ReopenOnHUP {
+		hupC = make(chan os.Signal, 1)
+		signal.Notify(hupC, syscall.SIGHUP)
+		defer signal.Stop(hupC)
+	}
+
+	for {
+		select {
+		case <-tickC.C:
+			if err := file.Flush(); err != nil {
+				log.Printf("klog: unable to flush file '%s': %s", file.Path, err)
+			}
+
+		case <-hupC:
+			if err := file.Reopen(); err != nil {
+				log.Printf("klog: unable to reopen file '%s': %s", file.Path, err)
+			}
+
+		case <-file.stopC:
+			return
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ReadFile(t *testing.T, path string) string {
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var reader io.Reader = fd
+
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func LineFormat(line *Line) []byte { return []byte(line.Value + "\n") }
+
+func TestFile_Flush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	file := NewFile(path)
+	file.Format = LineFormat
+
+	file.Print(L("a", "x"))
+	file.Print(L("a", "y"))
+
+	if body := ReadFile(t, path); body != "" {
+		t.Errorf("FAIL: expected buffered lines, got '%s'", body)
+	}
+
+	if err := file.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := ReadFile(t, path); body != "x\ny\n" {
+		t.Errorf("FAIL: unexpected content '%s'", body)
+	}
+
+	file.Print(L("a", "z"))
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Print(L("a", "w"))
+
+	if body := ReadFile(t, path); body != "x\ny\nz\n" {
+		t.Errorf("FAIL: unexpected content '%s'", body)
+	}
+}
+
+func TestFile_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	file := NewFile(path)
+	file.Format = LineFormat
+	file.MaxSize = 4
+	file.MaxBackups = 2
+	file.Compress = true
+
+	for _, value := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		file.Print(L("a", value))
+		file.Flush()
+		file.wait.Wait()
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := file.listBackups()
+	if len(backups) != 2 {
+		t.Fatalf("FAIL: expected 2 backups got %v", backups)
+	}
+
+	var content []string
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("FAIL: backup '%s' is not compressed", backup)
+		}
+		content = append(content, ReadFile(t, backup))
+	}
+	content = append(content, ReadFile(t, path))
+
+	if result := strings.Join(content, "|"); result != "c\nd\n|e\nf\n|g\n" {
+		t.Errorf("FAIL: unexpected content '%s'", result)
+	}
+}
+
+func TestFile_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	file := NewFile(path)
+	file.Format = LineFormat
+	defer file.Close()
+
+	file.Print(L("a", "x"))
+	file.Flush()
+
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Print(L("a", "y"))
+
+	if err := file.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Print(L("a", "z"))
+	file.Flush()
+
+	if body := ReadFile(t, path+".old"); body != "x\ny\n" {
+		t.Errorf("FAIL: unexpected old content '%s'", body)
+	}
+
+	if body := ReadFile(t, path); body != "z\n" {
+		t.Errorf("FAIL: unexpected content '%s'", body)
+	}
+}
+
+func TestFile_RotateGlobPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test[*?].log")
+
+	file := NewFile(path)
+	file.Format = LineFormat
+	file.MaxSize = 2
+	file.MaxBackups = 1
+
+	for _, value := range []string{"a", "b", "c"} {
+		file.Print(L("a", value))
+		file.Flush()
+		file.wait.Wait()
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := file.listBackups()
+	if len(backups) != 1 {
+		t.Fatalf("FAIL: expected 1 backup got %v", backups)
+	}
+
+	if body := ReadFile(t, backups[0]) + ReadFile(t, path); body != "b\nc\n" {
+		t.Errorf("FAIL: unexpected content '%s'", body)
+	}
+}
+
+func TestFile_Retry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "test.log")
+
+	if err := os.WriteFile(filepath.Join(dir, "sub"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file := NewFile(path)
+	file.Format = LineFormat
+	defer file.Close()
+
+	file.Print(L("a", "x"))
+	file.Print(L("a", "y"))
+
+	if file.delay != FileRetryDelay || file.retry.IsZero() {
+		t.Errorf("FAIL: unexpected retry delay %s", file.delay)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Print(L("a", "z"))
+	file.Flush()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("FAIL: file opened before the retry delay")
+	}
+
+	file.retry = time.Now()
+
+	file.Print(L("a", "w"))
+	file.Flush()
+
+	if body := ReadFile(t, path); body != "w\n" {
+		t.Errorf("FAIL: unexpected content '%s'", body)
+	}
+}
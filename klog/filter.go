@@ -5,9 +5,12 @@ package klog
 import (
 	"github.com/datacratic/goset"
 
+	"fmt"
 	"log"
-	"strings"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -20,22 +23,207 @@ const (
 	FilterIn = 2
 )
 
-const (
-	filterAdd = iota
-	filterRemove
-	filterAddPrefix
-	filterRemovePrefix
-	filterAddSuffix
-	filterRemoveSuffix
-)
+// filterRules is an immutable snapshot of the patterns of a filter. Mutations
+// are applied on a copy which is then compiled and atomically swapped in.
+type filterRules struct {
+	patterns [filterKinds]set.String
+	expires  map[filterPattern]time.Time
+	rules    []FilterRule
+
+	hits     map[filterPattern]*atomic.Uint64
+	ruleHits map[FilterRule]*atomic.Uint64
+
+	matchers []*filterMatcher
+
+	keyTrie      *keyTrie
+	prefixTrie   *keyTrie
+	suffixTrie   *keyTrie
+	keyGlobs     []*filterMatcher
+	keyRegexes   []*filterMatcher
+	valueRegexes []*filterMatcher
+}
 
-type filterOp struct {
-	Op    int
-	Value string
+func (rules *filterRules) copy() *filterRules {
+	result := new(filterRules)
+	for kind, patterns := range rules.patterns {
+		result.patterns[kind] = set.NewString(patterns.Array()...)
+	}
+	result.expires = make(map[filterPattern]time.Time)
+	for pattern, deadline := range rules.expires {
+		result.expires[pattern] = deadline
+	}
+	result.rules = append([]FilterRule(nil), rules.rules...)
+	result.hits = rules.hits
+	result.ruleHits = rules.ruleHits
+	return result
+}
+
+// compile indexes the patterns in tries keyed on the segments of the patterns
+// and compiles the globs, regexes and ordered rules. Returns an error if a
+// regex or a rule is invalid. Hit counters are carried over from the previous
+// snapshot for the patterns and rules that are still present.
+func (rules *filterRules) compile() (*filterRules, error) {
+	hits, ruleHits := rules.hits, rules.ruleHits
+	rules.hits = make(map[filterPattern]*atomic.Uint64)
+	rules.ruleHits = make(map[FilterRule]*atomic.Uint64)
+
+	rules.matchers = make([]*filterMatcher, len(rules.rules))
+	for i, rule := range rules.rules {
+		matcher, err := newFilterMatcher(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		id := FilterRule{Action: rule.Action, Kind: rule.Kind, Pattern: rule.Pattern}
+		if matcher.hits = ruleHits[id]; matcher.hits == nil {
+			matcher.hits = new(atomic.Uint64)
+		}
+		rules.ruleHits[id] = matcher.hits
+
+		rules.matchers[i] = matcher
+	}
+
+	for kind, patterns := range rules.patterns {
+		for value := range patterns {
+			pattern := filterPattern{FilterKind(kind), value}
+			if rules.hits[pattern] = hits[pattern]; rules.hits[pattern] == nil {
+				rules.hits[pattern] = new(atomic.Uint64)
+			}
+		}
+	}
+
+	rules.keyTrie = newKeyTrie()
+	for key := range rules.patterns[FilterKey] {
+		rules.keyTrie.insertKey(key)
+	}
+
+	rules.prefixTrie = newKeyTrie()
+	for prefix := range rules.patterns[FilterPrefix] {
+		rules.prefixTrie.insertPrefix(prefix)
+	}
+
+	rules.suffixTrie = newKeyTrie()
+	for suffix := range rules.patterns[FilterSuffix] {
+		rules.suffixTrie.insertSuffix(suffix)
+	}
+
+	var err error
+
+	if rules.keyGlobs, err = rules.compileMatchers(FilterGlob); err != nil {
+		return nil, err
+	}
+
+	if rules.keyRegexes, err = rules.compileMatchers(FilterKeyRegex); err != nil {
+		return nil, err
+	}
+
+	if rules.valueRegexes, err = rules.compileMatchers(FilterValueRegex); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (rules *filterRules) compileMatchers(kind FilterKind) (result []*filterMatcher, err error) {
+	for value := range rules.patterns[kind] {
+		matcher := &filterMatcher{FilterRule: FilterRule{Kind: kind, Pattern: value}}
+		if err = matcher.compile(); err != nil {
+			return
+		}
+
+		matcher.hits = rules.hits[filterPattern{kind, value}]
+		result = append(result, matcher)
+	}
+	return
+}
+
+// hitKey returns the hit counter of the first pattern matching the key or nil
+// if none of the patterns match.
+func (rules *filterRules) hitKey(key string) *atomic.Uint64 {
+	if pattern, ok := rules.keyTrie.matchKey(key); ok {
+		return rules.hits[filterPattern{FilterKey, pattern}]
+	}
+
+	if pattern, ok := rules.prefixTrie.matchPrefix(key); ok {
+		return rules.hits[filterPattern{FilterPrefix, pattern}]
+	}
+
+	if pattern, ok := rules.suffixTrie.matchSuffix(key); ok {
+		return rules.hits[filterPattern{FilterSuffix, pattern}]
+	}
+
+	for _, matcher := range rules.keyGlobs {
+		if matcher.matchKey(key) {
+			return matcher.hits
+		}
+	}
+
+	for _, matcher := range rules.keyRegexes {
+		if matcher.matchKey(key) {
+			return matcher.hits
+		}
+	}
+
+	return nil
+}
+
+// hitValue returns the hit counter of the first value regex matching the value
+// or nil if none of the regexes match.
+func (rules *filterRules) hitValue(value string) *atomic.Uint64 {
+	for _, matcher := range rules.valueRegexes {
+		if matcher.regex.MatchString(value) {
+			return matcher.hits
+		}
+	}
+
+	return nil
+}
+
+// decide returns the first rule matching the line or nil if no rules match.
+func (rules *filterRules) decide(key, value string) *filterMatcher {
+	for _, matcher := range rules.matchers {
+		if matcher.match(key, value) {
+			return matcher
+		}
+	}
+	return nil
+}
+
+// decideKey is the equivalent of decide when the value of the line isn't
+// known. Value rules that allow lines are assumed to match since the line could
+// be allowed while value rules that deny lines are assumed to not match.
+func (rules *filterRules) decideKey(key string) *filterMatcher {
+	for _, matcher := range rules.matchers {
+		if matcher.Kind == FilterValueRegex {
+			if matcher.Action == FilterAllow {
+				return matcher
+			}
+			continue
+		}
+
+		if matcher.matchKey(key) {
+			return matcher
+		}
+	}
+	return nil
 }
 
 // Filter can filter a line stream on the key of each line and discard any
-// undesired lines. Filters can either be a full, prefix or suffix string match.
+// undesired lines. Filters can either be a full, prefix, suffix or glob match
+// on the key or a regex match on either the key or the value. Key patterns are
+// indexed on the "."-separated segments of the keys so the cost of matching a
+// key depends on its depth and not on the number of patterns.
+//
+// Ordered rules can be used to mix allow and deny patterns within a single
+// filter (e.g. drop all "**.debug" lines except for "payments.**.debug"). The
+// rules are evaluated in order and the first matching rule decides the fate of
+// the line. Lines which don't match any rules fall back to the patterns and the
+// type of the filter.
+//
+// The patterns are published as an immutable snapshot which is read atomically
+// when printing so the filter can be used concurrently without going through a
+// background goroutine. Modifications are serialized and swap in a new
+// snapshot.
 type Filter struct {
 	Chained
 
@@ -51,15 +239,36 @@ type Filter struct {
 	// Suffixes is the initial set of patterns use for suffix matches.
 	Suffixes []string
 
+	// Globs is the initial set of patterns use for glob matches.
+	Globs []string
+
+	// KeyRegexes is the initial set of regexes matched against the key.
+	KeyRegexes []string
+
+	// ValueRegexes is the initial set of regexes matched against the value.
+	ValueRegexes []string
+
+	// Rules is the initial list of ordered rules.
+	Rules []FilterRule
+
+	// StatePath is the path of the file where the state of the filter is
+	// persisted. If set then the state is restored from the file when the
+	// filter is initialized, superseding the initial patterns and rules, and
+	// the file is rewritten after every change to the filter.
+	StatePath string
+
+	// MaxKeys is the number of recently seen keys reported by Stats. Defaults
+	// to DefaultFilterMaxKeys. If negative then keys are not tracked.
+	MaxKeys int
+
 	initialize sync.Once
 
-	keys     set.String
-	prefixes set.String
-	suffixes set.String
+	mutex sync.Mutex
+	rules atomic.Value
 
-	printC chan *Line
-	opC    chan filterOp
-	getC   chan chan map[string][]string
+	passed  atomic.Uint64
+	dropped atomic.Uint64
+	keys    *filterKeys
 }
 
 // NewFilter creates a new Filter configured to either FilterIn or FilterOut.
@@ -76,166 +285,332 @@ func (filter *Filter) init() {
 		log.Panicf("invalid filter default '%d'", filter.Type)
 	}
 
-	filter.keys = set.NewString(filter.Keys...)
-	filter.prefixes = set.NewString(filter.Prefixes...)
-	filter.suffixes = set.NewString(filter.Suffixes...)
+	if filter.MaxKeys == 0 {
+		filter.MaxKeys = DefaultFilterMaxKeys
+	}
+
+	if filter.MaxKeys > 0 {
+		filter.keys = newFilterKeys(filter.MaxKeys)
+	}
+
+	state := &FilterState{
+		Keys:         newFilterPatterns(filter.Keys),
+		Prefixes:     newFilterPatterns(filter.Prefixes),
+		Suffixes:     newFilterPatterns(filter.Suffixes),
+		Globs:        newFilterPatterns(filter.Globs),
+		KeyRegexes:   newFilterPatterns(filter.KeyRegexes),
+		ValueRegexes: newFilterPatterns(filter.ValueRegexes),
+		Rules:        filter.Rules,
+	}
+
+	rules, err := filter.build(state)
+	if err != nil {
+		log.Panicf("invalid filter: %s", err)
+	}
 
-	filter.printC = make(chan *Line, DefaultBufferC)
-	filter.opC = make(chan filterOp)
-	filter.getC = make(chan chan map[string][]string)
+	if len(filter.StatePath) > 0 {
+		if restored, err := filter.restore(filter.StatePath); err == nil {
+			rules = restored
+		} else if !os.IsNotExist(err) {
+			log.Printf("klog: unable to restore filter state from '%s': %s", filter.StatePath, err)
+		}
+	}
 
-	go filter.run()
+	filter.rules.Store(rules)
 }
 
-// Add adds the given pattern to be used as a full-key match.
-func (filter *Filter) Add(values ...string) *Filter {
-	filter.Init()
+// publish atomically swaps in the given snapshot and saves it to StatePath if
+// set. Must be called with the mutex held.
+func (filter *Filter) publish(rules *filterRules) {
+	filter.rules.Store(rules)
 
-	for _, value := range values {
-		filter.opC <- filterOp{filterAdd, value}
+	if len(filter.StatePath) == 0 {
+		return
 	}
 
-	return filter
+	if err := writeFilterState(filter.StatePath, rules.getState(time.Now())); err != nil {
+		log.Printf("klog: unable to save filter state to '%s': %s", filter.StatePath, err)
+	}
 }
 
-// Remove removes the given pattern to be used as a full-key match.
-func (filter *Filter) Remove(values ...string) *Filter {
-	filter.Init()
+func (filter *Filter) load() *filterRules {
+	return filter.rules.Load().(*filterRules)
+}
 
-	for _, value := range values {
-		filter.opC <- filterOp{filterRemove, value}
+// update adds or removes the values of the given kind. Added values expire
+// after the given ttl unless it's 0.
+func (filter *Filter) update(kind FilterKind, add bool, ttl time.Duration, values []string) error {
+	op := FilterOpRemove
+	if add {
+		op = FilterOpAdd
 	}
 
-	return filter
+	ops := make([]FilterOp, len(values))
+	for i, value := range values {
+		ops[i] = FilterOp{Op: op, Kind: kind, Pattern: value, TTL: ttl}
+	}
+
+	return filter.Apply(ops...)
 }
 
-// AddPrefix adds the given pattern to be used as a prefix match.
-func (filter *Filter) AddPrefix(prefixes ...string) *Filter {
+// expire removes all the patterns and rules whose ttl has elapsed.
+func (filter *Filter) expire() {
 	filter.Init()
 
-	for _, prefix := range prefixes {
-		filter.opC <- filterOp{filterAddPrefix, prefix}
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	now := time.Now()
+	rules := filter.load().copy()
+	expired := false
+
+	for pattern, deadline := range rules.expires {
+		if !deadline.After(now) {
+			rules.patterns[pattern.kind].Del(pattern.value)
+			delete(rules.expires, pattern)
+			expired = true
+		}
 	}
 
+	for i := 0; i < len(rules.rules); i++ {
+		if deadline := rules.rules[i].expires; !deadline.IsZero() && !deadline.After(now) {
+			rules.rules = append(rules.rules[:i], rules.rules[i+1:]...)
+			expired = true
+			i--
+		}
+	}
+
+	if !expired {
+		return
+	}
+
+	if _, err := rules.compile(); err != nil {
+		log.Printf("klog: unable to expire filter patterns: %s", err)
+		return
+	}
+
+	filter.publish(rules)
+}
+
+// AddTTL adds the given patterns of the given kind which are automatically
+// removed once the ttl has elapsed. Adding a pattern that is already present
+// resets its ttl. Returns an error and leaves the filter untouched if any of
+// the patterns are invalid.
+func (filter *Filter) AddTTL(kind FilterKind, ttl time.Duration, patterns ...string) error {
+	return filter.update(kind, true, ttl, patterns)
+}
+
+// Add adds the given pattern to be used as a full-key match.
+func (filter *Filter) Add(values ...string) *Filter {
+	filter.update(FilterKey, true, 0, values)
 	return filter
 }
 
-// RemovePrefix removes the given pattern to be used as a prefix match.
-func (filter *Filter) RemovePrefix(prefixes ...string) *Filter {
-	filter.Init()
+// Remove removes the given pattern to be used as a full-key match.
+func (filter *Filter) Remove(values ...string) *Filter {
+	filter.update(FilterKey, false, 0, values)
+	return filter
+}
 
-	for _, prefix := range prefixes {
-		filter.opC <- filterOp{filterRemovePrefix, prefix}
-	}
+// AddPrefix adds the given pattern to be used as a prefix match.
+func (filter *Filter) AddPrefix(prefixes ...string) *Filter {
+	filter.update(FilterPrefix, true, 0, prefixes)
+	return filter
+}
 
+// RemovePrefix removes the given pattern to be used as a prefix match.
+func (filter *Filter) RemovePrefix(prefixes ...string) *Filter {
+	filter.update(FilterPrefix, false, 0, prefixes)
 	return filter
 }
 
 // AddSuffix adds the given pattern to be used as a suffix match.
 func (filter *Filter) AddSuffix(suffixes ...string) *Filter {
-	filter.Init()
-
-	for _, suffix := range suffixes {
-		filter.opC <- filterOp{filterAddSuffix, suffix}
-	}
-
+	filter.update(FilterSuffix, true, 0, suffixes)
 	return filter
 }
 
 // RemoveSuffix removes the given pattern to be used as a suffix match.
 func (filter *Filter) RemoveSuffix(suffixes ...string) *Filter {
-	filter.Init()
+	filter.update(FilterSuffix, false, 0, suffixes)
+	return filter
+}
 
-	for _, suffix := range suffixes {
-		filter.opC <- filterOp{filterRemoveSuffix, suffix}
-	}
+// AddGlob adds the given pattern to be used as a glob match. Globs are matched
+// against the "."-separated segments of the key where "*" matches any single
+// segment and "**" matches zero or more segments (e.g. "db.*.slow" or
+// "**.debug").
+func (filter *Filter) AddGlob(globs ...string) *Filter {
+	filter.update(FilterGlob, true, 0, globs)
+	return filter
+}
 
+// RemoveGlob removes the given pattern to be used as a glob match.
+func (filter *Filter) RemoveGlob(globs ...string) *Filter {
+	filter.update(FilterGlob, false, 0, globs)
 	return filter
 }
 
-// Get returns the list of active filters.
-func (filter *Filter) Get() map[string][]string {
-	filter.Init()
+// AddKeyRegex adds the given regexes to be matched against the key of the
+// lines. Returns an error and leaves the filter untouched if any of the regexes
+// are invalid.
+func (filter *Filter) AddKeyRegex(exprs ...string) error {
+	return filter.update(FilterKeyRegex, true, 0, exprs)
+}
 
-	resultC := make(chan map[string][]string)
-	filter.getC <- resultC
-	return <-resultC
+// RemoveKeyRegex removes the given regexes matched against the key of the
+// lines.
+func (filter *Filter) RemoveKeyRegex(exprs ...string) *Filter {
+	filter.update(FilterKeyRegex, false, 0, exprs)
+	return filter
 }
 
-// Print forwards the line to the next printer if the filter is of type FilterIn
-// and at least one of the patterns match the key or if the filter is of type
-// FilterOut and none of the patterns match the key.
-func (filter *Filter) Print(line *Line) {
-	filter.Init()
-	filter.printC <- line
+// AddValueRegex adds the given regexes to be matched against the value of the
+// lines. Returns an error and leaves the filter untouched if any of the regexes
+// are invalid.
+func (filter *Filter) AddValueRegex(exprs ...string) error {
+	return filter.update(FilterValueRegex, true, 0, exprs)
 }
 
-func (filter *Filter) print(line *Line) {
-	hit := filter.keys.Test(line.Key)
+// RemoveValueRegex removes the given regexes matched against the value of the
+// lines.
+func (filter *Filter) RemoveValueRegex(exprs ...string) *Filter {
+	filter.update(FilterValueRegex, false, 0, exprs)
+	return filter
+}
 
-	if !hit {
-		for prefix := range filter.prefixes {
-			if hit = strings.HasPrefix(line.Key, prefix); hit {
-				break
-			}
-		}
-	}
+// updateRules applies the given function to a copy of the ordered rules of the
+// current snapshot before publishing the copy. If an error occurs then the
+// current snapshot is left untouched.
+func (filter *Filter) updateRules(fn func([]FilterRule) ([]FilterRule, error)) error {
+	filter.Init()
 
-	if !hit {
-		for suffix := range filter.suffixes {
-			if hit = strings.HasSuffix(line.Key, suffix); hit {
-				break
-			}
-		}
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	rules := filter.load().copy()
+
+	var err error
+	if rules.rules, err = fn(rules.rules); err != nil {
+		return err
 	}
 
-	if filter.Type == FilterOut && !hit {
-		filter.PrintNext(line)
-	} else if filter.Type == FilterIn && hit {
-		filter.PrintNext(line)
+	if _, err := rules.compile(); err != nil {
+		return err
 	}
+
+	filter.publish(rules)
+	return nil
 }
 
-func (filter *Filter) op(op int, value string) {
-	switch op {
+// AddRule appends the given rule to the list of ordered rules such that it's
+// evaluated last. Rules with a TTL are automatically removed once the TTL has
+// elapsed. Returns an error if the rule is invalid.
+func (filter *Filter) AddRule(rule FilterRule) error {
+	return filter.updateRules(func(rules []FilterRule) ([]FilterRule, error) {
+		return append(rules, filter.arm(rule)), nil
+	})
+}
 
-	case filterAdd:
-		filter.keys.Put(value)
-	case filterRemove:
-		filter.keys.Del(value)
+// InsertRule inserts the given rule at the given position in the list of
+// ordered rules such that it's evaluated before the rule currently at that
+// position. Returns an error if the rule is invalid or if the position is out
+// of range.
+func (filter *Filter) InsertRule(pos int, rule FilterRule) error {
+	return filter.updateRules(func(rules []FilterRule) ([]FilterRule, error) {
+		if pos < 0 || pos > len(rules) {
+			return nil, fmt.Errorf("rule position '%d' out of range", pos)
+		}
 
-	case filterAddPrefix:
-		filter.prefixes.Put(value)
-	case filterRemovePrefix:
-		filter.prefixes.Del(value)
+		rules = append(rules, FilterRule{})
+		copy(rules[pos+1:], rules[pos:])
+		rules[pos] = filter.arm(rule)
+		return rules, nil
+	})
+}
 
-	case filterAddSuffix:
-		filter.suffixes.Put(value)
-	case filterRemoveSuffix:
-		filter.suffixes.Del(value)
+// RemoveRule removes the rule at the given position in the list of ordered
+// rules. Returns an error if the position is out of range.
+func (filter *Filter) RemoveRule(pos int) error {
+	return filter.updateRules(func(rules []FilterRule) ([]FilterRule, error) {
+		if pos < 0 || pos >= len(rules) {
+			return nil, fmt.Errorf("rule position '%d' out of range", pos)
+		}
+		return append(rules[:pos], rules[pos+1:]...), nil
+	})
+}
 
-	default:
-		log.Panicf("unknown filter op type '%d'", op)
+// arm sets the deadline of a rule with a TTL and schedules its expiration. The
+// expiration is a noop if the rule is removed or rejected in the meantime.
+func (filter *Filter) arm(rule FilterRule) FilterRule {
+	rule.expires = time.Time{}
+	if rule.TTL > 0 {
+		rule.expires = time.Now().Add(rule.TTL)
+		time.AfterFunc(rule.TTL, filter.expire)
 	}
+	return rule
+}
+
+// GetRules returns the list of ordered rules in evaluation order. The TTL of
+// the rules is set to their remaining lifetime.
+func (filter *Filter) GetRules() []FilterRule {
+	filter.Init()
+	return filter.load().getRules(time.Now())
 }
 
-func (filter *Filter) get(resultC chan map[string][]string) {
-	resultC <- map[string][]string{
-		"keys":     filter.keys.Array(),
-		"prefixes": filter.prefixes.Array(),
-		"suffixes": filter.suffixes.Array(),
+// Enabled returns true if a line with the given key would be forwarded to the
+// next printer and if the rest of the pipeline would print it. Since the value
+// isn't known, value regexes are assumed to not match for FilterOut filters and
+// to possibly match for FilterIn filters. Querying a filter has no side-effects
+// and isn't accounted for in the stats of the filter.
+func (filter *Filter) Enabled(key string) bool {
+	filter.Init()
+
+	rules := filter.load()
+
+	if matcher := rules.decideKey(key); matcher != nil {
+		return matcher.Action == FilterAllow && filter.EnabledNext(key)
+	}
+
+	hit := rules.hitKey(key) != nil
+
+	if filter.Type == FilterIn && !hit {
+		hit = len(rules.valueRegexes) > 0
 	}
+
+	return filter.pass(hit) && filter.EnabledNext(key)
 }
 
-func (filter *Filter) run() {
-	for {
-		select {
-		case line := <-filter.printC:
-			filter.print(line)
-		case op := <-filter.opC:
-			filter.op(op.Op, op.Value)
-		case c := <-filter.getC:
-			filter.get(c)
+// Print forwards the line to the next printer if the first matching rule allows
+// it. If no rules match then the line is forwarded if the filter is of type
+// FilterIn and at least one of the patterns match the line or if the filter is
+// of type FilterOut and none of the patterns match the line.
+func (filter *Filter) Print(line *Line) {
+	filter.Init()
+
+	rules := filter.load()
+
+	var pass bool
+	var hits *atomic.Uint64
+
+	if matcher := rules.decide(line.Key, line.Value); matcher != nil {
+		pass, hits = matcher.Action == FilterAllow, matcher.hits
+	} else {
+		if hits = rules.hitKey(line.Key); hits == nil {
+			hits = rules.hitValue(line.Value)
 		}
+		pass = filter.pass(hits != nil)
 	}
+
+	if !pass {
+		filter.drop(line.Key, line.Timestamp, hits)
+		return
+	}
+
+	filter.accept(line.Key, line.Timestamp, hits)
+	filter.PrintNext(line)
+}
+
+func (filter *Filter) pass(hit bool) bool {
+	return (filter.Type == FilterOut && !hit) || (filter.Type == FilterIn && hit)
 }
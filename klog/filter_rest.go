@@ -4,6 +4,11 @@ package klog
 
 import (
 	"github.com/datacratic/gorest/rest"
+
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 // FilterREST provides the REST interface for the Filter chained printer.
@@ -24,30 +29,183 @@ func NewFilterREST(path string, def int) *FilterREST {
 }
 
 // RESTRoutes returns the set of gorest routes used to manipulate the Filter
-// chained printer.
+// chained printer. Regexes are passed as a json string in the body of the
+// request since they can contain characters which aren't valid in a path.
+// Ordered rules are passed as a json FilterRule object in the body of the
+// request and are addressed by their position in the evaluation order.
+//
+// Patterns can be added with a TTL (e.g. "15m") by appending it as a "ttl"
+// segment to the path of the PUT routes such that the pattern is automatically
+// removed once the TTL elapses. The TTL can also be passed as a "ttl" query
+// parameter when the endpoint is wrapped with WrapTTL.
+//
+// The whole state of the filter can be replaced atomically by a PUT on the
+// prefix with a json FilterState in the body while a PATCH on the prefix
+// applies a json list of FilterOp atomically.
+//
+// Changes made through REST are written through to Filter.StatePath if set.
 func (filter *FilterREST) RESTRoutes() rest.Routes {
-	prefix := filter.PathPrefix
-	if len(prefix) == 0 {
-		prefix = DefaultPathREST + "/filter"
-	}
+	prefix := filter.pathPrefix()
 
 	return []*rest.Route{
 		rest.NewRoute(prefix, "GET", filter.Get),
+		rest.NewRoute(prefix+"/state", "GET", filter.GetState),
+		rest.NewRoute(prefix, "PUT", filter.Set),
+		rest.NewRoute(prefix, "PATCH", filter.applyOps),
+		rest.NewRoute(prefix+"/stats", "GET", filter.Stats),
 
 		rest.NewRoute(prefix+"/key/:key", "PUT", filter.add),
+		rest.NewRoute(prefix+"/key/:key/ttl/:ttl", "PUT", filter.addKeyTTL),
 		rest.NewRoute(prefix+"/key/:key", "DELETE", filter.remove),
 
 		rest.NewRoute(prefix+"/prefix/:prefix", "PUT", filter.addPrefix),
+		rest.NewRoute(prefix+"/prefix/:prefix/ttl/:ttl", "PUT", filter.addPrefixTTL),
 		rest.NewRoute(prefix+"/prefix/:prefix", "DELETE", filter.removePrefix),
 
 		rest.NewRoute(prefix+"/suffix/:suffix", "PUT", filter.addSuffix),
+		rest.NewRoute(prefix+"/suffix/:suffix/ttl/:ttl", "PUT", filter.addSuffixTTL),
 		rest.NewRoute(prefix+"/suffix/:suffix", "DELETE", filter.removeSuffix),
+
+		rest.NewRoute(prefix+"/glob/:pattern", "PUT", filter.addGlob),
+		rest.NewRoute(prefix+"/glob/:pattern/ttl/:ttl", "PUT", filter.addGlobTTL),
+		rest.NewRoute(prefix+"/glob/:pattern", "DELETE", filter.removeGlob),
+
+		rest.NewRoute(prefix+"/regex/key", "PUT", filter.addKeyRegex),
+		rest.NewRoute(prefix+"/regex/key/ttl/:ttl", "PUT", filter.addKeyRegexTTL),
+		rest.NewRoute(prefix+"/regex/key", "DELETE", filter.removeKeyRegex),
+
+		rest.NewRoute(prefix+"/regex/value", "PUT", filter.addValueRegex),
+		rest.NewRoute(prefix+"/regex/value/ttl/:ttl", "PUT", filter.addValueRegexTTL),
+		rest.NewRoute(prefix+"/regex/value", "DELETE", filter.removeValueRegex),
+
+		rest.NewRoute(prefix+"/rules", "GET", filter.GetRules),
+		rest.NewRoute(prefix+"/rules", "POST", filter.AddRule),
+		rest.NewRoute(prefix+"/rules/:pos", "PUT", filter.insertRule),
+		rest.NewRoute(prefix+"/rules/:pos", "DELETE", filter.removeRule),
 	}
 }
 
+func (filter *FilterREST) pathPrefix() string {
+	if len(filter.PathPrefix) == 0 {
+		return DefaultPathREST + "/filter"
+	}
+	return filter.PathPrefix
+}
+
+// WrapTTL returns a handler which adds support for the "ttl" query parameter
+// (e.g. "PUT /debug/klog/filter/key/db.query?ttl=15m") to the PUT routes of
+// the patterns before forwarding the requests to the given handler. gorest
+// only passes the path parameters and the body of the requests to the handlers
+// so the query parameter is moved to the "ttl" path segment of the equivalent
+// TTL route. Other requests are forwarded untouched.
+func (filter *FilterREST) WrapTTL(handler http.Handler) http.Handler {
+	prefix := filter.pathPrefix() + "/"
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		ttl := query.Get("ttl")
+
+		if request.Method != "PUT" || len(ttl) == 0 || !strings.HasPrefix(request.URL.Path, prefix) {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+
+		if !isPatternRoute(strings.Split(request.URL.Path[len(prefix):], "/")) {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+
+		query.Del("ttl")
+
+		request = request.Clone(request.Context())
+		request.URL.Path += "/ttl/" + ttl
+		if len(request.URL.RawPath) > 0 {
+			request.URL.RawPath += "/ttl/" + url.PathEscape(ttl)
+		}
+		request.URL.RawQuery = query.Encode()
+		request.RequestURI = request.URL.RequestURI()
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// isPatternRoute returns true if the segments are the path of the PUT route of
+// a pattern relative to the prefix.
+func isPatternRoute(segments []string) bool {
+	if len(segments) != 2 {
+		return false
+	}
+
+	switch segments[0] {
+	case "key", "prefix", "suffix", "glob":
+		return len(segments[1]) > 0
+	case "regex":
+		return segments[1] == "key" || segments[1] == "value"
+	}
+
+	return false
+}
+
 func (filter *FilterREST) add(value string)          { filter.Add(value) }
 func (filter *FilterREST) remove(value string)       { filter.Remove(value) }
 func (filter *FilterREST) addPrefix(value string)    { filter.AddPrefix(value) }
 func (filter *FilterREST) removePrefix(value string) { filter.RemovePrefix(value) }
 func (filter *FilterREST) addSuffix(value string)    { filter.AddSuffix(value) }
 func (filter *FilterREST) removeSuffix(value string) { filter.RemoveSuffix(value) }
+func (filter *FilterREST) addGlob(value string)      { filter.AddGlob(value) }
+func (filter *FilterREST) removeGlob(value string)   { filter.RemoveGlob(value) }
+
+func (filter *FilterREST) addKeyRegex(expr string) error   { return filter.AddKeyRegex(expr) }
+func (filter *FilterREST) addValueRegex(expr string) error { return filter.AddValueRegex(expr) }
+func (filter *FilterREST) removeKeyRegex(expr string)      { filter.RemoveKeyRegex(expr) }
+func (filter *FilterREST) removeValueRegex(expr string)    { filter.RemoveValueRegex(expr) }
+
+func (filter *FilterREST) applyOps(ops []FilterOp) error { return filter.Apply(ops...) }
+
+func (filter *FilterREST) insertRule(pos string, rule FilterRule) error {
+	i, err := strconv.Atoi(pos)
+	if err != nil {
+		return err
+	}
+	return filter.InsertRule(i, rule)
+}
+
+func (filter *FilterREST) removeRule(pos string) error {
+	i, err := strconv.Atoi(pos)
+	if err != nil {
+		return err
+	}
+	return filter.RemoveRule(i)
+}
+
+func (filter *FilterREST) addTTL(kind FilterKind, ttl, value string) error {
+	duration, err := parseTTL(ttl)
+	if err != nil {
+		return err
+	}
+	return filter.AddTTL(kind, duration, value)
+}
+
+func (filter *FilterREST) addKeyTTL(value, ttl string) error {
+	return filter.addTTL(FilterKey, ttl, value)
+}
+
+func (filter *FilterREST) addPrefixTTL(value, ttl string) error {
+	return filter.addTTL(FilterPrefix, ttl, value)
+}
+
+func (filter *FilterREST) addSuffixTTL(value, ttl string) error {
+	return filter.addTTL(FilterSuffix, ttl, value)
+}
+
+func (filter *FilterREST) addGlobTTL(value, ttl string) error {
+	return filter.addTTL(FilterGlob, ttl, value)
+}
+
+func (filter *FilterREST) addKeyRegexTTL(ttl string, expr string) error {
+	return filter.addTTL(FilterKeyRegex, ttl, expr)
+}
+
+func (filter *FilterREST) addValueRegexTTL(ttl string, expr string) error {
+	return filter.addTTL(FilterValueRegex, ttl, expr)
+}
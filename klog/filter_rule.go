@@ -0,0 +1,251 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FilterKind indicates how a filter pattern is matched against a line.
+type FilterKind int
+
+const (
+	// FilterKey matches the full key of the line.
+	FilterKey FilterKind = iota
+
+	// FilterPrefix matches the start of the key of the line.
+	FilterPrefix
+
+	// FilterSuffix matches the end of the key of the line.
+	FilterSuffix
+
+	// FilterGlob matches the segments of the key of the line where "*" matches
+	// any single segment and "**" matches zero or more segments.
+	FilterGlob
+
+	// FilterKeyRegex matches a regex against the key of the line.
+	FilterKeyRegex
+
+	// FilterValueRegex matches a regex against the value of the line.
+	FilterValueRegex
+
+	filterKinds
+)
+
+var filterKindStrings = [filterKinds]string{
+	"key",
+	"prefix",
+	"suffix",
+	"glob",
+	"key-regex",
+	"value-regex",
+}
+
+// String returns the name of the kind.
+func (kind FilterKind) String() string {
+	if kind < 0 || kind >= filterKinds {
+		return fmt.Sprintf("kind(%d)", int(kind))
+	}
+	return filterKindStrings[kind]
+}
+
+// MarshalText encodes the kind as its name.
+func (kind FilterKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// UnmarshalText decodes the kind from its name.
+func (kind *FilterKind) UnmarshalText(text []byte) error {
+	for i, name := range filterKindStrings {
+		if name == string(text) {
+			*kind = FilterKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown filter kind '%s'", text)
+}
+
+// FilterAction indicates what to do with a line matched by a filter rule.
+type FilterAction int
+
+const (
+	// FilterAllow forwards the matched lines to the next printer.
+	FilterAllow FilterAction = iota + 1
+
+	// FilterDeny discards the matched lines.
+	FilterDeny
+)
+
+// String returns the name of the action.
+func (action FilterAction) String() string {
+	switch action {
+	case FilterAllow:
+		return "allow"
+	case FilterDeny:
+		return "deny"
+	}
+	return fmt.Sprintf("action(%d)", int(action))
+}
+
+// MarshalText encodes the action as its name.
+func (action FilterAction) MarshalText() ([]byte, error) {
+	return []byte(action.String()), nil
+}
+
+// UnmarshalText decodes the action from its name.
+func (action *FilterAction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "allow":
+		*action = FilterAllow
+	case "deny":
+		*action = FilterDeny
+	default:
+		return fmt.Errorf("unknown filter action '%s'", text)
+	}
+	return nil
+}
+
+// FilterRule associates an action to a pattern. Rules are evaluated in order
+// and the action of the first matching rule is applied to the line.
+type FilterRule struct {
+	Action  FilterAction
+	Kind    FilterKind
+	Pattern string
+
+	// TTL is the lifetime of the rule after which it's automatically removed.
+	// If 0 then the rule never expires. Rules returned by a filter have their
+	// TTL set to their remaining lifetime.
+	TTL time.Duration
+
+	expires time.Time
+}
+
+type filterRuleJSON struct {
+	Action  FilterAction `json:"action"`
+	Kind    FilterKind   `json:"kind"`
+	Pattern string       `json:"pattern"`
+	TTL     string       `json:"ttl,omitempty"`
+}
+
+// String returns a string representation of the rule.
+func (rule FilterRule) String() string {
+	if rule.TTL > 0 {
+		return fmt.Sprintf("%s %s '%s' ttl=%s", rule.Action, rule.Kind, rule.Pattern, rule.TTL)
+	}
+	return fmt.Sprintf("%s %s '%s'", rule.Action, rule.Kind, rule.Pattern)
+}
+
+// MarshalJSON encodes the rule as a json object where the TTL is formatted as
+// a duration string (e.g. "15m0s") and omitted if 0.
+func (rule FilterRule) MarshalJSON() ([]byte, error) {
+	result := filterRuleJSON{Action: rule.Action, Kind: rule.Kind, Pattern: rule.Pattern}
+	if rule.TTL > 0 {
+		result.TTL = rule.TTL.String()
+	}
+	return json.Marshal(&result)
+}
+
+// UnmarshalJSON decodes the rule from a json object.
+func (rule *FilterRule) UnmarshalJSON(data []byte) error {
+	var result filterRuleJSON
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	ttl, err := parseTTL(result.TTL)
+	if err != nil {
+		return err
+	}
+
+	*rule = FilterRule{Action: result.Action, Kind: result.Kind, Pattern: result.Pattern, TTL: ttl}
+	return nil
+}
+
+func parseTTL(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err == nil && ttl < 0 {
+		err = fmt.Errorf("negative ttl '%s'", value)
+	}
+	return ttl, err
+}
+
+// remaining returns the remaining lifetime of a deadline. Lifetimes above a
+// second are rounded to the second to keep them readable.
+func remaining(deadline, now time.Time) time.Duration {
+	ttl := deadline.Sub(now)
+	if ttl > time.Second {
+		ttl = ttl.Round(time.Second)
+	}
+	return ttl
+}
+
+type filterMatcher struct {
+	FilterRule
+
+	glob  keyGlob
+	regex *regexp.Regexp
+	hits  *atomic.Uint64
+}
+
+func newFilterMatcher(rule FilterRule) (*filterMatcher, error) {
+	if rule.Action != FilterAllow && rule.Action != FilterDeny {
+		return nil, fmt.Errorf("invalid filter action '%d'", int(rule.Action))
+	}
+
+	matcher := &filterMatcher{FilterRule: rule}
+	if err := matcher.compile(); err != nil {
+		return nil, err
+	}
+
+	return matcher, nil
+}
+
+func (matcher *filterMatcher) compile() (err error) {
+	switch matcher.Kind {
+
+	case FilterKey, FilterPrefix, FilterSuffix:
+
+	case FilterGlob:
+		matcher.glob = newKeyGlob(matcher.Pattern)
+
+	case FilterKeyRegex, FilterValueRegex:
+		matcher.regex, err = regexp.Compile(matcher.Pattern)
+
+	default:
+		err = fmt.Errorf("invalid filter kind '%d'", int(matcher.Kind))
+	}
+
+	return
+}
+
+func (matcher *filterMatcher) matchKey(key string) bool {
+	switch matcher.Kind {
+	case FilterKey:
+		return key == matcher.Pattern
+	case FilterPrefix:
+		return strings.HasPrefix(key, matcher.Pattern)
+	case FilterSuffix:
+		return strings.HasSuffix(key, matcher.Pattern)
+	case FilterGlob:
+		return matcher.glob.match(key)
+	case FilterKeyRegex:
+		return matcher.regex.MatchString(key)
+	}
+	return false
+}
+
+func (matcher *filterMatcher) match(key, value string) bool {
+	if matcher.Kind == FilterValueRegex {
+		return matcher.regex.MatchString(value)
+	}
+	return matcher.matchKey(key)
+}
@@ -0,0 +1,505 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"github.com/datacratic/goset"
+
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type filterPattern struct {
+	kind  FilterKind
+	value string
+}
+
+// FilterPattern is a pattern of a filter along with its remaining lifetime.
+// Patterns without a TTL are encoded in json as a plain string while patterns
+// with a TTL are encoded as a json object with a "pattern" and a "ttl" field.
+type FilterPattern struct {
+	Pattern string
+
+	// TTL is the remaining lifetime of the pattern. If 0 then the pattern never
+	// expires.
+	TTL time.Duration
+}
+
+func newFilterPatterns(values []string) []FilterPattern {
+	result := make([]FilterPattern, len(values))
+	for i, value := range values {
+		result[i].Pattern = value
+	}
+	return result
+}
+
+type filterPatternJSON struct {
+	Pattern string `json:"pattern"`
+	TTL     string `json:"ttl,omitempty"`
+}
+
+// MarshalJSON encodes the pattern as a json string if it doesn't have a TTL or
+// as a json object otherwise.
+func (pattern FilterPattern) MarshalJSON() ([]byte, error) {
+	if pattern.TTL <= 0 {
+		return json.Marshal(pattern.Pattern)
+	}
+	return json.Marshal(&filterPatternJSON{Pattern: pattern.Pattern, TTL: pattern.TTL.String()})
+}
+
+// UnmarshalJSON decodes the pattern from either a json string or a json
+// object.
+func (pattern *FilterPattern) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*pattern = FilterPattern{Pattern: value}
+		return nil
+	}
+
+	var result filterPatternJSON
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	ttl, err := parseTTL(result.TTL)
+	if err != nil {
+		return err
+	}
+
+	*pattern = FilterPattern{Pattern: result.Pattern, TTL: ttl}
+	return nil
+}
+
+// FilterState is a snapshot of the patterns and rules of a filter.
+type FilterState struct {
+	Keys         []FilterPattern `json:"keys"`
+	Prefixes     []FilterPattern `json:"prefixes"`
+	Suffixes     []FilterPattern `json:"suffixes"`
+	Globs        []FilterPattern `json:"globs"`
+	KeyRegexes   []FilterPattern `json:"key-regexes"`
+	ValueRegexes []FilterPattern `json:"value-regexes"`
+	Rules        []FilterRule    `json:"rules"`
+}
+
+func (state *FilterState) patterns(kind FilterKind) *[]FilterPattern {
+	switch kind {
+	case FilterKey:
+		return &state.Keys
+	case FilterPrefix:
+		return &state.Prefixes
+	case FilterSuffix:
+		return &state.Suffixes
+	case FilterGlob:
+		return &state.Globs
+	case FilterKeyRegex:
+		return &state.KeyRegexes
+	case FilterValueRegex:
+		return &state.ValueRegexes
+	}
+	return nil
+}
+
+// age removes the given duration from the TTL of all the patterns and rules
+// and discards those that would have expired.
+func (state *FilterState) age(elapsed time.Duration) {
+	for kind := FilterKind(0); kind < filterKinds; kind++ {
+		patterns := state.patterns(kind)
+
+		result := (*patterns)[:0]
+		for _, pattern := range *patterns {
+			if pattern.TTL > 0 {
+				if pattern.TTL -= elapsed; pattern.TTL <= 0 {
+					continue
+				}
+			}
+			result = append(result, pattern)
+		}
+		*patterns = result
+	}
+
+	rules := state.Rules[:0]
+	for _, rule := range state.Rules {
+		if rule.TTL > 0 {
+			if rule.TTL -= elapsed; rule.TTL <= 0 {
+				continue
+			}
+		}
+		rules = append(rules, rule)
+	}
+	state.Rules = rules
+}
+
+func (rules *filterRules) getState(now time.Time) *FilterState {
+	state := &FilterState{Rules: rules.getRules(now)}
+
+	for kind, patterns := range rules.patterns {
+		kind := FilterKind(kind)
+		result := state.patterns(kind)
+		*result = []FilterPattern{}
+
+		values := patterns.Array()
+		sort.Strings(values)
+
+		for _, value := range values {
+			pattern := FilterPattern{Pattern: value}
+
+			if deadline, ok := rules.expires[filterPattern{kind, value}]; ok {
+				if pattern.TTL = remaining(deadline, now); pattern.TTL <= 0 {
+					continue
+				}
+			}
+
+			*result = append(*result, pattern)
+		}
+	}
+
+	return state
+}
+
+func (rules *filterRules) getRules(now time.Time) []FilterRule {
+	result := []FilterRule{}
+
+	for _, rule := range rules.rules {
+		if !rule.expires.IsZero() {
+			if rule.TTL = remaining(rule.expires, now); rule.TTL <= 0 {
+				continue
+			}
+			rule.expires = time.Time{}
+		}
+
+		result = append(result, rule)
+	}
+
+	return result
+}
+
+// filterKindNames are the names used by Get for each kind of patterns which
+// match the json names of the patterns of FilterState.
+var filterKindNames = [filterKinds]string{
+	"keys",
+	"prefixes",
+	"suffixes",
+	"globs",
+	"key-regexes",
+	"value-regexes",
+}
+
+// Get returns the list of active patterns indexed by their kind. The remaining
+// lifetime of the patterns of a kind with at least one TTL is listed under the
+// name of the kind suffixed by "-ttl" (e.g. "keys-ttl") in the same order as
+// the patterns where patterns that never expire have an empty lifetime. Use
+// GetState to also retrieve the rules.
+func (filter *Filter) Get() map[string][]string {
+	filter.Init()
+
+	state := filter.GetState()
+	result := make(map[string][]string)
+
+	for kind := FilterKind(0); kind < filterKinds; kind++ {
+		patterns := *state.patterns(kind)
+
+		values := make([]string, len(patterns))
+		ttls := make([]string, len(patterns))
+		expires := false
+
+		for i, pattern := range patterns {
+			values[i] = pattern.Pattern
+
+			if pattern.TTL > 0 {
+				ttls[i] = pattern.TTL.String()
+				expires = true
+			}
+		}
+
+		result[filterKindNames[kind]] = values
+
+		if expires {
+			result[filterKindNames[kind]+"-ttl"] = ttls
+		}
+	}
+
+	return result
+}
+
+// GetState returns a snapshot of the active patterns and rules of the filter
+// along with their remaining lifetime.
+func (filter *Filter) GetState() *FilterState {
+	filter.Init()
+	return filter.load().getState(time.Now())
+}
+
+// build creates and compiles a new snapshot from the given state and schedules
+// the expiration of the patterns and rules with a TTL. Hit counters are carried
+// over from the current snapshot.
+func (filter *Filter) build(state *FilterState) (*filterRules, error) {
+	now := time.Now()
+
+	rules := &filterRules{expires: make(map[filterPattern]time.Time)}
+
+	for kind := FilterKind(0); kind < filterKinds; kind++ {
+		rules.patterns[kind] = set.NewString()
+
+		for _, pattern := range *state.patterns(kind) {
+			rules.patterns[kind].Put(pattern.Pattern)
+
+			if pattern.TTL > 0 {
+				rules.expires[filterPattern{kind, pattern.Pattern}] = now.Add(pattern.TTL)
+				time.AfterFunc(pattern.TTL, filter.expire)
+			}
+		}
+	}
+
+	for _, rule := range state.Rules {
+		rules.rules = append(rules.rules, filter.arm(rule))
+	}
+
+	if current, ok := filter.rules.Load().(*filterRules); ok {
+		rules.hits, rules.ruleHits = current.hits, current.ruleHits
+	}
+
+	return rules.compile()
+}
+
+// Set atomically replaces all the patterns and rules of the filter with the
+// given state which has the same shape as the state returned by GetState. If an
+// error occurs then the filter is left untouched.
+func (filter *Filter) Set(state *FilterState) error {
+	filter.Init()
+
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	rules, err := filter.build(state)
+	if err != nil {
+		return err
+	}
+
+	filter.publish(rules)
+	return nil
+}
+
+// Save writes the state of the filter as returned by GetState to the given
+// writer as json.
+func (filter *Filter) Save(writer io.Writer) error {
+	return json.NewEncoder(writer).Encode(filter.GetState())
+}
+
+// Load replaces all the patterns and rules of the filter with the json state
+// read from the given reader. If an error occurs then the filter is left
+// untouched.
+func (filter *Filter) Load(reader io.Reader) error {
+	state := new(FilterState)
+	if err := json.NewDecoder(reader).Decode(state); err != nil {
+		return err
+	}
+	return filter.Set(state)
+}
+
+// SaveFile writes the state of the filter to the given file. The file is
+// replaced atomically such that a concurrent reader never sees a partially
+// written file.
+func (filter *Filter) SaveFile(path string) error {
+	return writeFilterState(path, filter.GetState())
+}
+
+// LoadFile replaces all the patterns and rules of the filter with the state
+// read from the given file. The time elapsed since the file was last written
+// is deducted from the TTL of the patterns and rules so that those which
+// expired in the meantime are not restored.
+func (filter *Filter) LoadFile(path string) error {
+	state, err := readFilterState(path)
+	if err != nil {
+		return err
+	}
+	return filter.Set(state)
+}
+
+func (filter *Filter) restore(path string) (*filterRules, error) {
+	state, err := readFilterState(path)
+	if err != nil {
+		return nil, err
+	}
+	return filter.build(state)
+}
+
+func readFilterState(path string) (*FilterState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(FilterState)
+	if err := json.NewDecoder(file).Decode(state); err != nil {
+		return nil, err
+	}
+
+	if elapsed := time.Since(stat.ModTime()); elapsed > 0 {
+		state.age(elapsed)
+	}
+
+	return state, nil
+}
+
+func writeFilterState(path string, state *FilterState) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	err = json.NewEncoder(file).Encode(state)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	return
+}
+
+const (
+	// FilterOpAdd adds a pattern or appends a rule to a filter.
+	FilterOpAdd = "add"
+
+	// FilterOpRemove removes a pattern or the first equivalent rule from a
+	// filter.
+	FilterOpRemove = "remove"
+)
+
+// FilterOp is a single modification of a filter applied through Apply. Ops
+// with an Action apply to the ordered rules while ops without an Action apply
+// to the patterns of the given kind. TTL is only used when adding.
+type FilterOp struct {
+	Op      string
+	Action  FilterAction
+	Kind    FilterKind
+	Pattern string
+	TTL     time.Duration
+}
+
+type filterOpJSON struct {
+	Op      string       `json:"op"`
+	Action  FilterAction `json:"action,omitempty"`
+	Kind    FilterKind   `json:"kind"`
+	Pattern string       `json:"pattern"`
+	TTL     string       `json:"ttl,omitempty"`
+}
+
+// MarshalJSON encodes the op as a json object where the action and the TTL are
+// omitted if not set.
+func (op FilterOp) MarshalJSON() ([]byte, error) {
+	result := filterOpJSON{Op: op.Op, Action: op.Action, Kind: op.Kind, Pattern: op.Pattern}
+	if op.TTL > 0 {
+		result.TTL = op.TTL.String()
+	}
+	return json.Marshal(&result)
+}
+
+// UnmarshalJSON decodes the op from a json object.
+func (op *FilterOp) UnmarshalJSON(data []byte) error {
+	var result filterOpJSON
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	ttl, err := parseTTL(result.TTL)
+	if err != nil {
+		return err
+	}
+
+	*op = FilterOp{Op: result.Op, Action: result.Action, Kind: result.Kind, Pattern: result.Pattern, TTL: ttl}
+	return nil
+}
+
+// Apply applies the given ops in order and atomically publishes the result. If
+// any of the ops are invalid then none of the ops are applied.
+func (filter *Filter) Apply(ops ...FilterOp) error {
+	filter.Init()
+
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	now := time.Now()
+	rules := filter.load().copy()
+
+	for _, op := range ops {
+		if err := filter.applyOp(rules, op, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := rules.compile(); err != nil {
+		return err
+	}
+
+	filter.publish(rules)
+	return nil
+}
+
+func (filter *Filter) applyOp(rules *filterRules, op FilterOp, now time.Time) error {
+	if op.Op != FilterOpAdd && op.Op != FilterOpRemove {
+		return fmt.Errorf("unknown filter op '%s'", op.Op)
+	}
+
+	if op.Action != 0 {
+		rule := FilterRule{Action: op.Action, Kind: op.Kind, Pattern: op.Pattern, TTL: op.TTL}
+
+		if op.Op == FilterOpAdd {
+			rules.rules = append(rules.rules, filter.arm(rule))
+			return nil
+		}
+
+		for i, other := range rules.rules {
+			if other.Action == rule.Action && other.Kind == rule.Kind && other.Pattern == rule.Pattern {
+				rules.rules = append(rules.rules[:i], rules.rules[i+1:]...)
+				break
+			}
+		}
+
+		return nil
+	}
+
+	if op.Kind < 0 || op.Kind >= filterKinds {
+		return fmt.Errorf("invalid filter kind '%d'", int(op.Kind))
+	}
+
+	pattern := filterPattern{op.Kind, op.Pattern}
+	delete(rules.expires, pattern)
+
+	if op.Op == FilterOpRemove {
+		rules.patterns[op.Kind].Del(op.Pattern)
+		return nil
+	}
+
+	rules.patterns[op.Kind].Put(op.Pattern)
+
+	if op.TTL > 0 {
+		rules.expires[pattern] = now.Add(op.TTL)
+		time.AfterFunc(op.TTL, filter.expire)
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFilterMaxKeys is used when Filter.MaxKeys is left empty.
+const DefaultFilterMaxKeys = 256
+
+// FilterHits is the number of lines matched by a pattern or a rule of a filter.
+// Action is only set for ordered rules.
+type FilterHits struct {
+	Action  FilterAction `json:"action,omitempty"`
+	Kind    FilterKind   `json:"kind"`
+	Pattern string       `json:"pattern"`
+	Hits    uint64       `json:"hits"`
+}
+
+// FilterKeyStats is the number of lines seen by a filter for a given key.
+type FilterKeyStats struct {
+	Key      string    `json:"key"`
+	Count    uint64    `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// FilterStats reports the activity of a filter.
+type FilterStats struct {
+	// Passed is the number of lines forwarded to the next printer.
+	Passed uint64 `json:"passed"`
+
+	// Dropped is the number of lines discarded by the filter. Lines skipped by
+	// callers through Enabled never reach the filter and aren't counted.
+	Dropped uint64 `json:"dropped"`
+
+	// Patterns are the hit counts of the patterns ordered by kind and pattern.
+	Patterns []FilterHits `json:"patterns"`
+
+	// Rules are the hit counts of the ordered rules in evaluation order.
+	Rules []FilterHits `json:"rules"`
+
+	// Keys are the recently seen keys ordered from the most recent to the least
+	// recent. Keys are evicted with the clock algorithm which approximates the
+	// least recently seen key.
+	Keys []FilterKeyStats `json:"keys"`
+}
+
+type filterKey struct {
+	key        string
+	count      atomic.Uint64
+	seen       atomic.Int64
+	referenced atomic.Bool
+}
+
+// filterKeys is a bounded set of recently seen keys. Keys that are already
+// present are updated under a read lock to keep the contention low while new
+// keys evict a key that wasn't seen recently once the set is full.
+//
+// Evictions use the clock algorithm to avoid having to reorder the keys on
+// every line: the keys are stored in a ring where seeing a key sets its
+// referenced flag and the hand of the clock skips over referenced keys while
+// clearing their flag until it finds a key to evict. Each key is skipped at
+// most once per turn of the hand so evictions take constant amortized time.
+type filterKeys struct {
+	max int
+
+	mutex sync.RWMutex
+	keys  map[string]*filterKey
+	ring  []*filterKey
+	hand  int
+}
+
+func newFilterKeys(max int) *filterKeys {
+	return &filterKeys{max: max, keys: make(map[string]*filterKey)}
+}
+
+func (keys *filterKeys) record(key string, ts time.Time) {
+	if keys == nil {
+		return
+	}
+
+	keys.mutex.RLock()
+	entry, ok := keys.keys[key]
+	keys.mutex.RUnlock()
+
+	if !ok {
+		entry = keys.insert(key)
+	}
+
+	entry.count.Add(1)
+	entry.seen.Store(ts.UnixNano())
+
+	// Avoids writing to the shared flag when it's already set.
+	if !entry.referenced.Load() {
+		entry.referenced.Store(true)
+	}
+}
+
+func (keys *filterKeys) insert(key string) *filterKey {
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+
+	if entry, ok := keys.keys[key]; ok {
+		return entry
+	}
+
+	entry := &filterKey{key: key}
+	keys.keys[key] = entry
+
+	if len(keys.ring) < keys.max {
+		keys.ring = append(keys.ring, entry)
+		return entry
+	}
+
+	for keys.ring[keys.hand].referenced.Swap(false) {
+		keys.hand = (keys.hand + 1) % len(keys.ring)
+	}
+
+	delete(keys.keys, keys.ring[keys.hand].key)
+	keys.ring[keys.hand] = entry
+	keys.hand = (keys.hand + 1) % len(keys.ring)
+
+	return entry
+}
+
+func (keys *filterKeys) get() []FilterKeyStats {
+	result := []FilterKeyStats{}
+	if keys == nil {
+		return result
+	}
+
+	keys.mutex.RLock()
+	for key, entry := range keys.keys {
+		result = append(result, FilterKeyStats{
+			Key:      key,
+			Count:    entry.count.Load(),
+			LastSeen: time.Unix(0, entry.seen.Load()),
+		})
+	}
+	keys.mutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+func (filter *Filter) accept(key string, ts time.Time, hits *atomic.Uint64) {
+	if hits != nil {
+		hits.Add(1)
+	}
+	filter.passed.Add(1)
+	filter.keys.record(key, ts)
+}
+
+func (filter *Filter) drop(key string, ts time.Time, hits *atomic.Uint64) {
+	if hits != nil {
+		hits.Add(1)
+	}
+	filter.dropped.Add(1)
+	filter.keys.record(key, ts)
+}
+
+// Stats returns the number of lines passed and dropped by the filter along with
+// the hit counts of each pattern and rule and the most recently seen keys.
+// Hit counts are only incremented for the pattern or rule that decided the fate
+// of the line.
+func (filter *Filter) Stats() *FilterStats {
+	filter.Init()
+
+	rules := filter.load()
+
+	stats := &FilterStats{
+		Passed:   filter.passed.Load(),
+		Dropped:  filter.dropped.Load(),
+		Patterns: []FilterHits{},
+		Rules:    []FilterHits{},
+		Keys:     filter.keys.get(),
+	}
+
+	for kind, patterns := range rules.patterns {
+		values := patterns.Array()
+		sort.Strings(values)
+
+		for _, value := range values {
+			stats.Patterns = append(stats.Patterns, FilterHits{
+				Kind:    FilterKind(kind),
+				Pattern: value,
+				Hits:    rules.hits[filterPattern{FilterKind(kind), value}].Load(),
+			})
+		}
+	}
+
+	for _, matcher := range rules.matchers {
+		stats.Rules = append(stats.Rules, FilterHits{
+			Action:  matcher.Action,
+			Kind:    matcher.Kind,
+			Pattern: matcher.Pattern,
+			Hits:    matcher.hits.Load(),
+		})
+	}
+
+	return stats
+}
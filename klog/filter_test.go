@@ -3,8 +3,18 @@
 package klog
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func DoFilterPrints(printer Printer) {
@@ -220,11 +230,12 @@ func BenchFilterPrefix(b *testing.B, n int, hit int) {
 	}
 }
 
-func BenchmarkFilter_PrefixMiss0(b *testing.B)  { BenchFilterPrefix(b, 0, Miss) }
-func BenchmarkFilter_PrefixMiss1(b *testing.B)  { BenchFilterPrefix(b, 1, Miss) }
-func BenchmarkFilter_PrefixMiss8(b *testing.B)  { BenchFilterPrefix(b, 8, Miss) }
-func BenchmarkFilter_PrefixMiss16(b *testing.B) { BenchFilterPrefix(b, 16, Miss) }
-func BenchmarkFilter_PrefixMiss32(b *testing.B) { BenchFilterPrefix(b, 32, Miss) }
+func BenchmarkFilter_PrefixMiss0(b *testing.B)   { BenchFilterPrefix(b, 0, Miss) }
+func BenchmarkFilter_PrefixMiss1(b *testing.B)   { BenchFilterPrefix(b, 1, Miss) }
+func BenchmarkFilter_PrefixMiss8(b *testing.B)   { BenchFilterPrefix(b, 8, Miss) }
+func BenchmarkFilter_PrefixMiss16(b *testing.B)  { BenchFilterPrefix(b, 16, Miss) }
+func BenchmarkFilter_PrefixMiss32(b *testing.B)  { BenchFilterPrefix(b, 32, Miss) }
+func BenchmarkFilter_PrefixMiss256(b *testing.B) { BenchFilterPrefix(b, 256, Miss) }
 
 func BenchmarkFilter_PrefixHitEarly0(b *testing.B)  { BenchFilterPrefix(b, 0, Early) }
 func BenchmarkFilter_PrefixHitEarly1(b *testing.B)  { BenchFilterPrefix(b, 1, Early) }
@@ -232,8 +243,679 @@ func BenchmarkFilter_PrefixHitEarly8(b *testing.B)  { BenchFilterPrefix(b, 8, Ea
 func BenchmarkFilter_PrefixHitEarly16(b *testing.B) { BenchFilterPrefix(b, 16, Early) }
 func BenchmarkFilter_PrefixHitEarly32(b *testing.B) { BenchFilterPrefix(b, 32, Early) }
 
-func BenchmarkFilter_PrefixHitLate0(b *testing.B)  { BenchFilterPrefix(b, 0, Late) }
-func BenchmarkFilter_PrefixHitLate1(b *testing.B)  { BenchFilterPrefix(b, 1, Late) }
-func BenchmarkFilter_PrefixHitLate8(b *testing.B)  { BenchFilterPrefix(b, 8, Late) }
-func BenchmarkFilter_PrefixHitLate16(b *testing.B) { BenchFilterPrefix(b, 16, Late) }
-func BenchmarkFilter_PrefixHitLate32(b *testing.B) { BenchFilterPrefix(b, 32, Late) }
+func BenchmarkFilter_PrefixHitLate0(b *testing.B)   { BenchFilterPrefix(b, 0, Late) }
+func BenchmarkFilter_PrefixHitLate1(b *testing.B)   { BenchFilterPrefix(b, 1, Late) }
+func BenchmarkFilter_PrefixHitLate8(b *testing.B)   { BenchFilterPrefix(b, 8, Late) }
+func BenchmarkFilter_PrefixHitLate16(b *testing.B)  { BenchFilterPrefix(b, 16, Late) }
+func BenchmarkFilter_PrefixHitLate32(b *testing.B)  { BenchFilterPrefix(b, 32, Late) }
+func BenchmarkFilter_PrefixHitLate256(b *testing.B) { BenchFilterPrefix(b, 256, Late) }
+
+func BenchFilterParallel(b *testing.B, printer Printer) {
+	l := L("a", "x")
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			printer.Print(l)
+		}
+	})
+}
+
+// HopPrinter forwards all lines to a single background goroutine which is how
+// the filter used to serialize its prints. Used as a baseline for the parallel
+// benchmarks.
+type HopPrinter struct {
+	Chained
+	printC chan *Line
+}
+
+func NewHopPrinter(next Printer) *HopPrinter {
+	printer := &HopPrinter{printC: make(chan *Line, DefaultBufferC)}
+	printer.Chain(next)
+
+	go func() {
+		for line := range printer.printC {
+			printer.PrintNext(line)
+		}
+	}()
+
+	return printer
+}
+
+func (printer *HopPrinter) Print(line *Line) { printer.printC <- line }
+
+func NewBenchFilter() *Filter {
+	filter := &Filter{Type: FilterOut}
+	for i := 0; i < 8; i++ {
+		filter.AddPrefix(strconv.Itoa(i))
+	}
+	return filter
+}
+
+func BenchmarkFilter_Parallel(b *testing.B) {
+	BenchFilterParallel(b, NewBenchFilter())
+}
+
+func BenchmarkFilter_ParallelHop(b *testing.B) {
+	printer := NewHopPrinter(NewBenchFilter())
+	defer close(printer.printC)
+
+	BenchFilterParallel(b, printer)
+}
+
+// BenchmarkFilter_ParallelUnique prints lines with keys that are never seen
+// twice such that every line has to evict a tracked key.
+func BenchmarkFilter_ParallelUnique(b *testing.B) {
+	filter := NewBenchFilter()
+
+	keys := make([]*Line, 1<<16)
+	for i := range keys {
+		keys[i] = L("client."+strconv.Itoa(i), "x")
+	}
+
+	var offset atomic.Int64
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := int(offset.Add(1) << 12); pb.Next(); i++ {
+			filter.Print(keys[i%len(keys)])
+		}
+	})
+}
+
+func TestFilter_Concurrent(t *testing.T) {
+	var passed atomic.Int64
+
+	filter := &Filter{Type: FilterOut}
+	filter.Chain(PrinterFunc(func(line *Line) {
+		if line.Key == "b" {
+			passed.Add(1)
+		}
+	}))
+
+	var wait sync.WaitGroup
+	stopC := make(chan struct{})
+
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stopC:
+				return
+			default:
+			}
+
+			filter.Add("a")
+			filter.AddPrefix("a." + strconv.Itoa(i%4))
+			filter.Remove("a")
+			filter.RemovePrefix("a." + strconv.Itoa(i%4))
+		}
+	}()
+
+	var printers sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		printers.Add(1)
+		go func() {
+			defer printers.Done()
+			for j := 0; j < 1000; j++ {
+				filter.Print(L("a", "x"))
+				filter.Print(L("a.1", "x"))
+				filter.Enabled("a")
+				filter.Print(L("b", "x"))
+			}
+		}()
+	}
+
+	printers.Wait()
+	close(stopC)
+	wait.Wait()
+
+	if n := passed.Load(); n != 4000 {
+		t.Errorf("FAIL: expected 4000 lines for b got %d", n)
+	}
+
+	if !filter.Enabled("a") || !filter.Enabled("a.1") {
+		t.Errorf("FAIL: patterns were not removed")
+	}
+}
+
+func TestFilter_Glob(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterOut, Globs: []string{"a.*"}}
+	filter.Chain(out)
+
+	filter.AddGlob("**.b.a", "c.**")
+
+	DoFilterPrints(filter)
+	out.ExpectOrdered(
+		"<a> x",
+		"<a.b.c> x",
+		"<a.c.b> x",
+
+		"<b> x",
+		"<b.c> x",
+		"<b.a.c> x",
+		"<b.c.a> x",
+	)
+
+	filter.RemoveGlob("a.*", "c.**")
+
+	if globs := filter.GetState().Globs; len(globs) != 1 || globs[0].Pattern != "**.b.a" {
+		t.Errorf("FAIL: unexpected globs %v", globs)
+	}
+}
+
+func TestFilter_Regex(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterOut}
+	filter.Chain(out)
+
+	if err := filter.AddKeyRegex(`^b\.[ac]$`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.AddValueRegex("reset by peer"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.AddKeyRegex("a", "(b"); err == nil {
+		t.Error("FAIL: expected error on invalid regex")
+	}
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("b.c", "x"))
+	filter.Print(L("b.c.a", "x"))
+	filter.Print(L("c", "connection reset by peer"))
+	filter.Print(L("c", "connection refused"))
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<b.c.a> x",
+		"<c> connection refused",
+	)
+
+	state := filter.GetState()
+	if len(state.KeyRegexes) != 1 || len(state.ValueRegexes) != 1 {
+		t.Errorf("FAIL: unexpected patterns %v", state)
+	}
+
+	filter.RemoveKeyRegex(`^b\.[ac]$`)
+	filter.RemoveValueRegex("reset by peer")
+
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("c", "connection reset by peer"))
+
+	out.ExpectOrdered(
+		"<b.a> x",
+		"<c> connection reset by peer",
+	)
+}
+
+func TestFilter_RegexEnabled(t *testing.T) {
+	filter := &Filter{Type: FilterIn}
+	filter.Chain(NilPrinter)
+
+	if filter.Enabled("a") {
+		t.Error("FAIL: a should be disabled")
+	}
+
+	filter.AddValueRegex("x")
+
+	if !filter.Enabled("a") {
+		t.Error("FAIL: a could match a value regex")
+	}
+}
+
+func TestFilter_Rules(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{
+		Type: FilterOut,
+		Rules: []FilterRule{
+			{Action: FilterDeny, Kind: FilterGlob, Pattern: "**.debug"},
+		},
+	}
+	filter.Chain(out)
+
+	if err := filter.InsertRule(0, FilterRule{Action: FilterAllow, Kind: FilterGlob, Pattern: "payments.**.debug"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.AddRule(FilterRule{Action: FilterDeny, Kind: FilterValueRegex, Pattern: "("}); err == nil {
+		t.Error("FAIL: expected error on invalid regex")
+	}
+
+	if err := filter.InsertRule(3, FilterRule{Action: FilterDeny, Kind: FilterKey, Pattern: "a"}); err == nil {
+		t.Error("FAIL: expected error on out of range position")
+	}
+
+	filter.Print(L("a.debug", "x"))
+	filter.Print(L("a.info", "x"))
+	filter.Print(L("payments.card.debug", "x"))
+	filter.Print(L("payments.debug", "x"))
+
+	out.ExpectOrdered(
+		"<a.info> x",
+		"<payments.card.debug> x",
+		"<payments.debug> x",
+	)
+
+	if !filter.Enabled("payments.card.debug") || filter.Enabled("a.debug") {
+		t.Error("FAIL: unexpected enabled state")
+	}
+
+	rules := filter.GetRules()
+	if len(rules) != 2 || rules[0].Action != FilterAllow || rules[1].Action != FilterDeny {
+		t.Errorf("FAIL: unexpected rules %v", rules)
+	}
+
+	if err := filter.RemoveRule(0); err != nil {
+		t.Fatal(err)
+	}
+
+	filter.Print(L("payments.card.debug", "x"))
+	out.ExpectOrdered()
+}
+
+func TestFilter_RulesFallback(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterIn, Keys: []string{"a", "b"}}
+	filter.Chain(out)
+
+	filter.AddRule(FilterRule{Action: FilterDeny, Kind: FilterKey, Pattern: "b"})
+	filter.AddRule(FilterRule{Action: FilterAllow, Kind: FilterValueRegex, Pattern: "^important"})
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b", "x"))
+	filter.Print(L("c", "x"))
+	filter.Print(L("c", "important x"))
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<c> important x",
+	)
+
+	if !filter.Enabled("c") || filter.Enabled("b") {
+		t.Error("FAIL: unexpected enabled state")
+	}
+}
+
+func TestFilterRule_JSON(t *testing.T) {
+	rule := FilterRule{Action: FilterAllow, Kind: FilterKeyRegex, Pattern: "^a"}
+
+	data, err := json.Marshal(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := `{"action":"allow","kind":"key-regex","pattern":"^a"}`; string(data) != exp {
+		t.Errorf("FAIL: %s != %s", data, exp)
+	}
+
+	var result FilterRule
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result != rule {
+		t.Errorf("FAIL: %v != %v", result, rule)
+	}
+
+	if err := json.Unmarshal([]byte(`{"action":"drop","kind":"key"}`), &result); err == nil {
+		t.Error("FAIL: expected error on invalid action")
+	}
+}
+
+func TestFilter_TTL(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterIn}
+	filter.Chain(out)
+
+	filter.Add("a")
+
+	if err := filter.AddTTL(FilterPrefix, 20*time.Millisecond, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.AddRule(FilterRule{Action: FilterAllow, Kind: FilterKey, Pattern: "c", TTL: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.AddTTL(FilterKeyRegex, time.Hour, "("); err == nil {
+		t.Error("FAIL: expected error on invalid regex")
+	}
+
+	patterns := filter.Get()
+	if len(patterns["prefixes"]) != 1 || patterns["prefixes"][0] != "b" || len(patterns["globs"]) != 0 {
+		t.Errorf("FAIL: unexpected patterns %v", patterns)
+	}
+
+	if ttls := patterns["prefixes-ttl"]; len(ttls) != 1 {
+		t.Errorf("FAIL: unexpected prefix ttls %v", patterns)
+	} else if ttl, err := time.ParseDuration(ttls[0]); err != nil || ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Errorf("FAIL: unexpected prefix ttl '%s'", ttls[0])
+	}
+
+	if _, ok := patterns["keys-ttl"]; ok {
+		t.Errorf("FAIL: unexpected key ttls %v", patterns)
+	}
+
+	state := filter.GetState()
+	if len(state.Prefixes) != 1 || state.Prefixes[0].TTL <= 0 || state.Prefixes[0].TTL > 20*time.Millisecond {
+		t.Errorf("FAIL: unexpected prefixes %v", state.Prefixes)
+	}
+	if len(state.Rules) != 1 || state.Rules[0].TTL <= 0 {
+		t.Errorf("FAIL: unexpected rules %v", state.Rules)
+	}
+	if len(state.Keys) != 1 || state.Keys[0].TTL != 0 {
+		t.Errorf("FAIL: unexpected keys %v", state.Keys)
+	}
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("c", "x"))
+
+	out.ExpectOrdered("<a> x", "<b.a> x", "<c> x")
+
+	time.Sleep(50 * time.Millisecond)
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("c", "x"))
+
+	out.ExpectOrdered("<a> x")
+
+	if state := filter.GetState(); len(state.Prefixes) != 0 || len(state.Rules) != 0 {
+		t.Errorf("FAIL: expired patterns still present %v", state)
+	}
+}
+
+func TestFilterREST_WrapTTL(t *testing.T) {
+	filter := &FilterREST{Filter: NewFilter(FilterIn)}
+
+	var path, query string
+	handler := filter.WrapTTL(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		path, query = request.URL.Path, request.URL.RawQuery
+	}))
+
+	test := func(method, target, expPath, expQuery string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+		if path != expPath || query != expQuery {
+			t.Errorf("FAIL: %s %s -> '%s' '%s' != '%s' '%s'", method, target, path, query, expPath, expQuery)
+		}
+	}
+
+	prefix := DefaultPathREST + "/filter"
+
+	test("PUT", prefix+"/key/a.b?ttl=15m", prefix+"/key/a.b/ttl/15m", "")
+	test("PUT", prefix+"/glob/a.*?ttl=1h&x=y", prefix+"/glob/a.*/ttl/1h", "x=y")
+	test("PUT", prefix+"/regex/value?ttl=5s", prefix+"/regex/value/ttl/5s", "")
+	test("PUT", prefix+"/key/a.b", prefix+"/key/a.b", "")
+	test("PUT", prefix+"/key/a.b/ttl/1m?ttl=15m", prefix+"/key/a.b/ttl/1m", "ttl=15m")
+	test("PUT", prefix+"/rules/0?ttl=15m", prefix+"/rules/0", "ttl=15m")
+	test("DELETE", prefix+"/key/a.b?ttl=15m", prefix+"/key/a.b", "ttl=15m")
+	test("PUT", "/other/key/a.b?ttl=15m", "/other/key/a.b", "ttl=15m")
+}
+
+func TestFilterState_JSON(t *testing.T) {
+	state := &FilterState{
+		Keys: []FilterPattern{{Pattern: "a"}, {Pattern: "b", TTL: 15 * time.Minute}},
+	}
+
+	data, err := json.Marshal(state.Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := `["a",{"pattern":"b","ttl":"15m0s"}]`; string(data) != exp {
+		t.Errorf("FAIL: %s != %s", data, exp)
+	}
+
+	var keys []FilterPattern
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0] != state.Keys[0] || keys[1] != state.Keys[1] {
+		t.Errorf("FAIL: %v != %v", keys, state.Keys)
+	}
+}
+
+func TestFilter_Stats(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterOut, MaxKeys: 2}
+	filter.Chain(out)
+
+	filter.AddPrefix("a")
+	filter.AddRule(FilterRule{Action: FilterAllow, Kind: FilterKey, Pattern: "a.b"})
+
+	filter.Print(L("a.b", "x"))
+	filter.Print(L("a.c", "x"))
+	filter.Print(L("a.c", "x"))
+	filter.Print(L("b", "x"))
+	filter.Print(L("c", "x"))
+
+	if filter.Enabled("a.d") {
+		t.Error("FAIL: expected a.d to be disabled")
+	}
+
+	out.ExpectOrdered("<a.b> x", "<b> x", "<c> x")
+
+	stats := filter.Stats()
+
+	if stats.Passed != 3 || stats.Dropped != 2 {
+		t.Errorf("FAIL: unexpected counts passed=%d dropped=%d", stats.Passed, stats.Dropped)
+	}
+
+	if len(stats.Patterns) != 1 || stats.Patterns[0].Pattern != "a" || stats.Patterns[0].Hits != 2 {
+		t.Errorf("FAIL: unexpected pattern hits %v", stats.Patterns)
+	}
+
+	if len(stats.Rules) != 1 || stats.Rules[0].Pattern != "a.b" || stats.Rules[0].Hits != 1 {
+		t.Errorf("FAIL: unexpected rule hits %v", stats.Rules)
+	}
+
+	if len(stats.Keys) != 2 || stats.Keys[0].Key != "c" || stats.Keys[1].Key != "b" {
+		t.Errorf("FAIL: unexpected keys %v", stats.Keys)
+	}
+
+	filter.AddPrefix("b")
+	if stats := filter.Stats(); len(stats.Patterns) != 2 || stats.Patterns[0].Hits != 2 {
+		t.Errorf("FAIL: hit counts not carried over %v", stats.Patterns)
+	}
+}
+
+func TestFilter_StatsEviction(t *testing.T) {
+	filter := &Filter{Type: FilterOut, MaxKeys: 4}
+	filter.Chain(NilPrinter)
+
+	for i := 0; i < 1000; i++ {
+		filter.Print(L("hot", "x"))
+		filter.Print(L("cold."+strconv.Itoa(i), "x"))
+	}
+
+	// Keys seen between evictions must survive the unique keys.
+	keys := filter.Stats().Keys
+	if len(keys) != 4 {
+		t.Fatalf("FAIL: unexpected keys %v", keys)
+	}
+
+	found := false
+	for _, key := range keys {
+		found = found || key.Key == "hot"
+	}
+
+	if !found || keys[0].Key != "cold.999" {
+		t.Errorf("FAIL: unexpected keys %v", keys)
+	}
+}
+
+func TestFilter_StatsFork(t *testing.T) {
+	out := &TestPrinter{T: t}
+
+	filter := &Filter{Type: FilterIn}
+	filter.Chain(NilPrinter)
+
+	logger := New(Fork(filter, out), NilPrinter)
+	logger.KPrint("a", "x")
+
+	out.ExpectOrdered("<a> x")
+
+	stats := filter.Stats()
+
+	if stats.Passed != 0 || stats.Dropped != 1 {
+		t.Errorf("FAIL: unexpected counts passed=%d dropped=%d", stats.Passed, stats.Dropped)
+	}
+
+	if len(stats.Keys) != 1 || stats.Keys[0].Key != "a" || stats.Keys[0].Count != 1 {
+		t.Errorf("FAIL: unexpected keys %v", stats.Keys)
+	}
+}
+
+func TestFilter_SaveLoad(t *testing.T) {
+	src := &Filter{Type: FilterOut}
+	src.Add("a").AddGlob("b.*")
+	src.AddTTL(FilterPrefix, time.Hour, "c")
+	src.AddRule(FilterRule{Action: FilterAllow, Kind: FilterKey, Pattern: "b.x"})
+
+	buffer := new(bytes.Buffer)
+	if err := src.Save(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &TestPrinter{T: t}
+	dst := &Filter{Type: FilterOut, Keys: []string{"d"}}
+	dst.Chain(out)
+
+	if err := dst.Load(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.Print(L("a", "x"))
+	dst.Print(L("b.a", "x"))
+	dst.Print(L("b.x", "x"))
+	dst.Print(L("c.a", "x"))
+	dst.Print(L("d", "x"))
+
+	out.ExpectOrdered("<b.x> x", "<d> x")
+
+	if state := dst.GetState(); len(state.Prefixes) != 1 || state.Prefixes[0].TTL <= 0 {
+		t.Errorf("FAIL: unexpected prefixes %v", state.Prefixes)
+	}
+
+	if err := dst.Load(strings.NewReader(`{"key-regexes":["("]}`)); err == nil {
+		t.Error("FAIL: expected error on invalid regex")
+	}
+}
+
+func TestFilter_StatePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter", "state.json")
+
+	src := &Filter{Type: FilterOut, StatePath: path}
+	src.Add("a")
+	src.AddTTL(FilterKey, time.Hour, "b")
+	src.AddTTL(FilterKey, 30*time.Minute, "c")
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend that the file was written 45 minutes ago.
+	old := time.Now().Add(-45 * time.Minute)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &Filter{Type: FilterOut, Keys: []string{"d"}, StatePath: path}
+
+	keys := dst.GetState().Keys
+	if len(keys) != 2 || keys[0].Pattern != "a" || keys[1].Pattern != "b" {
+		t.Fatalf("FAIL: unexpected keys %v", keys)
+	}
+
+	if keys[1].TTL <= 0 || keys[1].TTL > 15*time.Minute {
+		t.Errorf("FAIL: unexpected ttl %s", keys[1].TTL)
+	}
+
+	dst.Remove("a")
+
+	if keys := (&Filter{Type: FilterOut, StatePath: path}).GetState().Keys; len(keys) != 1 || keys[0].Pattern != "b" {
+		t.Errorf("FAIL: changes not written through %v", keys)
+	}
+}
+
+func TestFilter_Set(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterOut, Keys: []string{"a"}}
+	filter.Chain(out)
+
+	var state FilterState
+	doc := `{"prefixes": ["b"], "rules": [{"action": "allow", "kind": "key", "pattern": "b.x"}]}`
+	if err := json.Unmarshal([]byte(doc), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.Set(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("b.x", "x"))
+
+	out.ExpectOrdered("<a> x", "<b.x> x")
+
+	state.Globs = []FilterPattern{{Pattern: "c"}}
+	state.KeyRegexes = []FilterPattern{{Pattern: "("}}
+	if err := filter.Set(&state); err == nil {
+		t.Error("FAIL: expected error on invalid regex")
+	}
+
+	if globs := filter.GetState().Globs; len(globs) != 0 {
+		t.Errorf("FAIL: failed set was applied %v", globs)
+	}
+}
+
+func TestFilter_Apply(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := &Filter{Type: FilterOut, Keys: []string{"a"}}
+	filter.Chain(out)
+
+	var ops []FilterOp
+	doc := `[
+		{"op": "remove", "kind": "key", "pattern": "a"},
+		{"op": "add", "kind": "prefix", "pattern": "b", "ttl": "1h"},
+		{"op": "add", "action": "allow", "kind": "glob", "pattern": "b.*.x"}
+	]`
+	if err := json.Unmarshal([]byte(doc), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter.Apply(ops...); err != nil {
+		t.Fatal(err)
+	}
+
+	filter.Print(L("a", "x"))
+	filter.Print(L("b.a", "x"))
+	filter.Print(L("b.a.x", "x"))
+
+	out.ExpectOrdered("<a> x", "<b.a.x> x")
+
+	err := filter.Apply(
+		FilterOp{Op: FilterOpRemove, Action: FilterAllow, Kind: FilterGlob, Pattern: "b.*.x"},
+		FilterOp{Op: "replace", Kind: FilterKey, Pattern: "c"},
+	)
+	if err == nil {
+		t.Error("FAIL: expected error on unknown op")
+	}
+
+	if rules := filter.GetRules(); len(rules) != 1 {
+		t.Errorf("FAIL: failed apply was applied %v", rules)
+	}
+
+	filter.Apply(FilterOp{Op: FilterOpRemove, Action: FilterAllow, Kind: FilterGlob, Pattern: "b.*.x"})
+
+	if rules := filter.GetRules(); len(rules) != 0 {
+		t.Errorf("FAIL: rule not removed %v", rules)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"strings"
+)
+
+// keyGlob is a pattern matched against the "."-separated segments of a key
+// where the segment "*" matches any single segment and the segment "**"
+// matches zero or more segments. All other segments must match exactly.
+type keyGlob []string
+
+// newKeyGlob splits the pattern into its segments. Consecutive "**" segments
+// are equivalent to a single one so they're collapsed.
+func newKeyGlob(pattern string) keyGlob {
+	var glob keyGlob
+
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment == "**" && len(glob) > 0 && glob[len(glob)-1] == "**" {
+			continue
+		}
+		glob = append(glob, segment)
+	}
+
+	return glob
+}
+
+// match walks the key and the glob in lockstep and only remembers the position
+// of the last "**" segment. On a mismatch, that "**" absorbs one more segment
+// of the key and the walk resumes right after it. Earlier "**" segments never
+// need to be revisited so the cost is bounded by the number of key segments
+// times the number of glob segments instead of growing exponentially with the
+// number of "**" segments.
+func (glob keyGlob) match(key string) bool {
+	i, done := 0, false
+
+	star, starKey, starDone := -1, "", false
+
+	for {
+		if i < len(glob) && glob[i] == "**" {
+			star, starKey, starDone = i, key, done
+			i++
+			continue
+		}
+
+		if done {
+			if i == len(glob) {
+				return true
+			}
+
+		} else if i < len(glob) {
+			segment, rest, more := strings.Cut(key, ".")
+
+			if glob[i] == "*" || glob[i] == segment {
+				key, done = rest, !more
+				i++
+				continue
+			}
+		}
+
+		if star < 0 || starDone {
+			return false
+		}
+
+		_, rest, more := strings.Cut(starKey, ".")
+		starKey, starDone = rest, !more
+		key, done = starKey, starDone
+		i = star + 1
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyGlob(t *testing.T) {
+	test := func(pattern, key string, exp bool) {
+		if result := newKeyGlob(pattern).match(key); result != exp {
+			t.Errorf("FAIL: '%s' ~ '%s': %t != %t", pattern, key, result, exp)
+		}
+	}
+
+	test("a.b", "a.b", true)
+	test("a.b", "a.b.c", false)
+	test("a.b", "a", false)
+
+	test("db.*.slow", "db.query.slow", true)
+	test("db.*.slow", "db.slow", false)
+	test("db.*.slow", "db.a.b.slow", false)
+	test("*", "a", true)
+	test("*", "a.b", false)
+
+	test("**.debug", "debug", true)
+	test("**.debug", "a.debug", true)
+	test("**.debug", "a.b.c.debug", true)
+	test("**.debug", "a.debug.b", false)
+	test("a.**", "a", true)
+	test("a.**", "a.b.c", true)
+	test("a.**", "b.a", false)
+	test("a.**.b", "a.b", true)
+	test("a.**.b", "a.x.y.b", true)
+	test("a.**.b", "a.x.y.c", false)
+	test("**", "a.b.c", true)
+	test("a.**.**", "a", true)
+	test("**.*.b", "b", false)
+	test("**.*.b", "x.b", true)
+}
+
+func TestKeyGlob_Backtracking(t *testing.T) {
+	test := func(pattern, key string, exp bool) {
+		if result := newKeyGlob(pattern).match(key); result != exp {
+			t.Errorf("FAIL: '%s' ~ '%s': %t != %t", pattern, key, result, exp)
+		}
+	}
+
+	test("**.a.**.b", "a.x.a.y.b", true)
+	test("**.a.**.b", "x.a.y.c", false)
+	test("**.a.*.b", "a.a.x.b", true)
+	test("**.a.*.b", "a.a.b", true)
+	test("**.a.*.b", "a.b", false)
+	test("a.**.**.b", "a.b", true)
+	test("**.**.**", "a.b", true)
+	test("**.**.x", "", false)
+
+	if glob := newKeyGlob("**.**.a.**.**.**"); len(glob) != 3 {
+		t.Errorf("FAIL: '**' segments weren't collapsed %v", glob)
+	}
+}
+
+func TestKeyGlob_Linear(t *testing.T) {
+	patterns := []string{
+		"**.**.**.**.**.**.**.**.x",
+		"**.a.**.a.**.a.**.a.**.x",
+		"**.*.**.*.**.*.**.*.**.x",
+	}
+
+	keyOf := func(n int) string {
+		return strings.TrimSuffix(strings.Repeat("a.", n), ".")
+	}
+
+	// The minimum over several runs keeps the measure stable on a loaded
+	// machine.
+	measure := func(glob keyGlob, key string) time.Duration {
+		min := time.Duration(math.MaxInt64)
+
+		for run := 0; run < 5; run++ {
+			start := time.Now()
+			for i := 0; i < 100; i++ {
+				if glob.match(key) {
+					t.Fatalf("FAIL: unexpected match for '%s'", glob)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < min {
+				min = elapsed
+			}
+		}
+
+		return min
+	}
+
+	for _, pattern := range patterns {
+		glob := newKeyGlob(pattern)
+
+		short := measure(glob, keyOf(1000))
+		long := measure(glob, keyOf(8000))
+
+		// A linear matcher is 8 times slower on the long key and a quadratic
+		// one would be 64 times slower.
+		if long > 24*short {
+			t.Errorf("FAIL: '%s' doesn't scale linearly: %s -> %s", pattern, short, long)
+		}
+	}
+}
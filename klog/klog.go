@@ -2,6 +2,10 @@
 
 package klog
 
+import (
+	"time"
+)
+
 // DefaultBufferC is the default channel size for stages that defer printing to
 // background go-routines. A well tuned parameter can reduce the overhead of
 // going through a channel while introducing a slight delay in the output of the
@@ -11,3 +15,7 @@ const DefaultBufferC = 8
 // DefaultPathREST is the default REST path prefix used if none is provided
 // explicitly.
 const DefaultPathREST = "/debug/klog"
+
+// DefaultFatalTimeout is the maximum amount of time that KFatal will wait for
+// the pipeline to shutdown before exiting.
+const DefaultFatalTimeout = 1 * time.Second
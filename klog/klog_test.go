@@ -16,7 +16,7 @@ func WaitForPropagation() {
 }
 
 func L(key, value string) *Line {
-	return &Line{time.Now(), key, value}
+	return &Line{Timestamp: time.Now(), Key: key, Value: value}
 }
 
 func Simplify(lines []*Line) (result []string) {
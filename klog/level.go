@@ -0,0 +1,151 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level represents the severity of a line.
+type Level int
+
+const (
+	// LevelNone indicates that no severity was attached to the line.
+	LevelNone Level = iota
+
+	// LevelDebug is used for verbose output which is only useful when
+	// investigating a problem.
+	LevelDebug
+
+	// LevelInfo is used for regular operational messages.
+	LevelInfo
+
+	// LevelWarn is used for unexpected events that can be recovered from.
+	LevelWarn
+
+	// LevelError is used for failures that require attention.
+	LevelError
+
+	// LevelFatal is used by the KFatal and KPanic functions.
+	LevelFatal
+)
+
+var levelNames = []string{"", "debug", "info", "warn", "error", "fatal"}
+
+// String returns the name of the level.
+func (level Level) String() string {
+	if level < 0 || int(level) >= len(levelNames) {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the level associated with the given name.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	}
+	return LevelNone, fmt.Errorf("unknown level '%s'", name)
+}
+
+// MarshalText encodes the level as its name.
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText decodes a level from its name.
+func (level *Level) UnmarshalText(text []byte) (err error) {
+	if len(text) == 0 {
+		*level = LevelNone
+		return
+	}
+
+	*level, err = ParseLevel(string(text))
+	return
+}
+
+// KeyLevel derives a level from the last segment of a key using the legacy
+// naming scheme where keys end with their severity (e.g. "a.b.debug"). The key
+// stripped of the level suffix is returned along with the level. If the suffix
+// isn't a known level then the key is returned unmodified with LevelNone.
+func KeyLevel(key string) (string, Level) {
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return key, LevelNone
+	}
+
+	level, err := ParseLevel(key[i+1:])
+	if err != nil {
+		return key, LevelNone
+	}
+
+	return key[:i], level
+}
+
+// LevelFilter discards all lines with a level lower then the configured
+// threshold. Lines without a level are always forwarded.
+type LevelFilter struct {
+	Chained
+
+	// Min is the initial threshold under which lines are discarded.
+	Min Level
+
+	// Legacy indicates that the level of lines without one should be derived
+	// from the suffix of their key via KeyLevel.
+	Legacy bool
+
+	initialize sync.Once
+
+	min int32
+}
+
+// NewLevelFilter creates a new LevelFilter with the given threshold.
+func NewLevelFilter(min Level) *LevelFilter { return &LevelFilter{Min: min} }
+
+// Init initializes the object. Calling this is optional since the object will
+// lazily initialize itself when needed.
+func (filter *LevelFilter) Init() {
+	filter.initialize.Do(filter.init)
+}
+
+func (filter *LevelFilter) init() {
+	filter.min = int32(filter.Min)
+}
+
+// SetMin changes the threshold of the filter.
+func (filter *LevelFilter) SetMin(min Level) {
+	filter.Init()
+	atomic.StoreInt32(&filter.min, int32(min))
+}
+
+// GetMin returns the current threshold of the filter.
+func (filter *LevelFilter) GetMin() Level {
+	filter.Init()
+	return Level(atomic.LoadInt32(&filter.min))
+}
+
+// Print forwards the line to the next printer if its level is greater or equal
+// to the threshold.
+func (filter *LevelFilter) Print(line *Line) {
+	level := line.Level
+
+	if level == LevelNone && filter.Legacy {
+		_, level = KeyLevel(line.Key)
+	}
+
+	if level == LevelNone || level >= filter.GetMin() {
+		filter.PrintNext(line)
+	}
+}
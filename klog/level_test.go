@@ -0,0 +1,95 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLevel_Parse(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal} {
+		if result, err := ParseLevel(level.String()); err != nil || result != level {
+			t.Errorf("FAIL: %s -> %s (%v)", level, result, err)
+		}
+	}
+
+	if _, err := ParseLevel("bob"); err == nil {
+		t.Error("FAIL: expected error for unknown level")
+	}
+
+	if result, err := ParseLevel("WARNING"); err != nil || result != LevelWarn {
+		t.Errorf("FAIL: WARNING -> %s (%v)", result, err)
+	}
+}
+
+func TestLevel_KeyLevel(t *testing.T) {
+	test := func(key, expKey string, expLevel Level) {
+		if resultKey, resultLevel := KeyLevel(key); resultKey != expKey || resultLevel != expLevel {
+			t.Errorf("FAIL: %s -> (%s, %s) != (%s, %s)",
+				key, resultKey, resultLevel, expKey, expLevel)
+		}
+	}
+
+	test("a.b.debug", "a.b", LevelDebug)
+	test("a.error", "a", LevelError)
+	test("a.b.c", "a.b.c", LevelNone)
+	test("debug", "debug", LevelNone)
+	test("", "", LevelNone)
+}
+
+func LL(key, value string, level Level) *Line {
+	line := L(key, value)
+	line.Level = level
+	return line
+}
+
+func TestLevelFilter(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := NewLevelFilter(LevelInfo)
+	filter.Chain(out)
+
+	filter.Print(LL("a", "x", LevelDebug))
+	filter.Print(LL("a", "y", LevelInfo))
+	filter.Print(LL("a", "z", LevelError))
+	filter.Print(L("a.debug", "x"))
+
+	out.ExpectOrdered(
+		"<a> y",
+		"<a> z",
+		"<a.debug> x",
+	)
+
+	filter.Legacy = true
+	filter.SetMin(LevelError)
+
+	filter.Print(LL("a", "x", LevelWarn))
+	filter.Print(L("a.debug", "x"))
+	filter.Print(L("a.error", "y"))
+	filter.Print(L("a.b", "z"))
+
+	out.ExpectOrdered(
+		"<a.error> y",
+		"<a.b> z",
+	)
+}
+
+func TestLevelFilter_Dedup(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := NewLevelFilter(LevelInfo)
+	filter.Chain(out)
+
+	dedup := &Dedup{Rate: time.Hour}
+	dedup.Chain(filter)
+
+	for i := 0; i < 3; i++ {
+		dedup.Print(LL("a", "x", LevelDebug))
+		dedup.Print(LL("b", "y", LevelWarn))
+	}
+	dedup.Close()
+
+	out.ExpectOrdered(
+		"<b> y",
+		"<b> y [2 times]",
+	)
+}
@@ -3,20 +3,152 @@
 package klog
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"time"
 )
 
+// Field is a typed key/value pair attached to a line. The type of the value is
+// preserved all the way to the printers which may use it to produce structured
+// output.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a new field with the given key and value.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err creates a new field under the "error" key containing the error message.
+// Errors don't have a useful JSON representation so the message is used
+// instead.
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error"}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// ReservedFieldPrefix is prepended to the key of the fields which collide with
+// a key reserved by a printer or a stage (e.g. a field named "key" is emitted
+// as "field.key" by JsonPrinter).
+const ReservedFieldPrefix = "field."
+
+// Fields is an ordered list of fields.
+type Fields []Field
+
+// escape returns the fields where the keys found in reserved are prefixed by
+// ReservedFieldPrefix. The fields are only copied if a key needs to be
+// escaped.
+func (fields Fields) escape(reserved map[string]bool) Fields {
+	var result Fields
+
+	for i, field := range fields {
+		if !reserved[field.Key] {
+			continue
+		}
+
+		if result == nil {
+			result = append(Fields(nil), fields...)
+		}
+		result[i].Key = ReservedFieldPrefix + field.Key
+	}
+
+	if result == nil {
+		return fields
+	}
+	return result
+}
+
+// String returns a string representation of the fields as a list of
+// space-separated key=value pairs.
+func (fields Fields) String() string {
+	buffer := new(bytes.Buffer)
+
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(' ')
+		}
+		fmt.Fprintf(buffer, "%s=%v", field.Key, field.Value)
+	}
+
+	return buffer.String()
+}
+
+// MarshalJSON encodes the fields as a JSON object while preserving the order of
+// the fields.
+func (fields Fields) MarshalJSON() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte('{')
+	fields.appendJSON(buffer, true)
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (fields Fields) appendJSON(buffer *bytes.Buffer, first bool) {
+	for _, field := range fields {
+		key, _ := json.Marshal(field.Key)
+
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(field.Value))
+		}
+
+		if !first {
+			buffer.WriteByte(',')
+		}
+		first = false
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+}
+
 // Line represents a line to be printed by a printer pipeline.
 type Line struct {
 	Timestamp time.Time `json:"ts"`
 	Key       string    `json:"key"`
 	Value     string    `json:"val"`
+	Level     Level     `json:"level,omitempty"`
+	TraceID   string    `json:"trace,omitempty"`
+	SpanID    string    `json:"span,omitempty"`
+	Fields    Fields    `json:"fields,omitempty"`
 }
 
 // String returns a string representation of the line.
 func (line *Line) String() string {
-	return fmt.Sprintf("%s <%s> %s", line.Timestamp, line.Key, line.Value)
+	return fmt.Sprintf("%s %s", line.Timestamp, line.text())
+}
+
+// text returns a string representation of the line without its timestamp.
+func (line *Line) text() string {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "<%s> ", line.Key)
+
+	if line.Level != LevelNone {
+		fmt.Fprintf(buffer, "[%s] ", line.Level)
+	}
+
+	buffer.WriteString(line.Value)
+
+	if len(line.TraceID) > 0 {
+		fmt.Fprintf(buffer, " trace=%s", line.TraceID)
+	}
+
+	if len(line.SpanID) > 0 {
+		fmt.Fprintf(buffer, " span=%s", line.SpanID)
+	}
+
+	if len(line.Fields) > 0 {
+		buffer.WriteByte(' ')
+		buffer.WriteString(line.Fields.String())
+	}
+
+	return buffer.String()
 }
 
 type lineArray []*Line
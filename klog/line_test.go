@@ -0,0 +1,111 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFields_JSON(t *testing.T) {
+	fields := Fields{F("b", 1), F("a", "x"), F("c", true), Err(errors.New("boom"))}
+
+	js, err := fields.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := `{"b":1,"a":"x","c":true,"error":"boom"}`; string(js) != exp {
+		t.Errorf("FAIL: %s != %s", js, exp)
+	}
+
+	if exp := "b=1 a=x c=true error=boom"; fields.String() != exp {
+		t.Errorf("FAIL: %s != %s", fields.String(), exp)
+	}
+}
+
+func TestLine_JSON(t *testing.T) {
+	line := &Line{
+		Key:    "a.b.info",
+		Value:  "x",
+		Fields: Fields{F("z", 1), F("y", []int{1, 2})},
+	}
+
+	js, err := marshalLine(line, "a.b", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"ts":"0001-01-01T00:00:00Z","key":"a.b","val":"x","level":"info","z":1,"y":[1,2]}`
+	if string(js) != exp {
+		t.Errorf("FAIL: %s != %s", js, exp)
+	}
+
+	if line.Key != "a.b.info" {
+		t.Errorf("FAIL: line key was modified to '%s'", line.Key)
+	}
+}
+
+func TestLine_JSONReserved(t *testing.T) {
+	line := &Line{
+		Key:    "a",
+		Value:  "x",
+		Fields: Fields{F("key", "b"), F("z", 1), F("val", "y")},
+	}
+
+	js, err := marshalLine(line, line.Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"ts":"0001-01-01T00:00:00Z","key":"a","val":"x","field.key":"b","z":1,"field.val":"y"}`
+	if string(js) != exp {
+		t.Errorf("FAIL: %s != %s", js, exp)
+	}
+
+	if line.Fields[0].Key != "key" {
+		t.Errorf("FAIL: line fields were modified to '%s'", line.Fields)
+	}
+}
+
+func TestFields_Pipeline(t *testing.T) {
+	out := &TestPrinter{T: t}
+
+	filter := NewFilter(FilterOut)
+	dedup := &Dedup{Rate: 10 * time.Millisecond}
+	logger := New(Chain(filter, Chain(dedup, out)), NilPrinter)
+
+	logger.KPrintw("a", "x", F("n", 1))
+	logger.KPrintw("a", "x", F("n", 1))
+	logger.KPrintw("a", "x", F("n", 1))
+
+	lines := out.GetLines(2)
+	if len(lines) != 2 {
+		t.Fatalf("FAIL: expected 2 lines got %d", len(lines))
+	}
+
+	if fields := lines[0].Fields; len(fields) != 1 || fields[0] != F("n", 1) {
+		t.Errorf("FAIL: unexpected fields '%s'", fields)
+	}
+
+	fields := lines[1].Fields
+	if len(fields) != 4 || fields[0] != F("n", 1) || fields[1] != F("count", 2) {
+		t.Fatalf("FAIL: unexpected summary fields '%s'", fields)
+	}
+
+	for i, key := range []string{"first_ts", "last_ts"} {
+		field := fields[i+2]
+
+		ts, ok := field.Value.(time.Time)
+		if field.Key != key || !ok || ts.Before(lines[0].Timestamp) || ts.After(lines[1].Timestamp) {
+			t.Errorf("FAIL: unexpected %s field '%v'", key, field)
+		}
+
+		if strings.Contains(fmt.Sprint(ts), "m=") {
+			t.Errorf("FAIL: %s carries a monotonic clock reading '%v'", key, ts)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package klog
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -12,6 +13,10 @@ import (
 type Logger struct {
 	Chained
 	Fatal Printer
+
+	root   *Logger
+	prefix string
+	fields Fields
 }
 
 // New creates a new Logger which outputs to the given printer.
@@ -19,23 +24,240 @@ func New(next Printer, fatal Printer) *Logger {
 	return &Logger{Chained: Chained{Next: next}, Fatal: fatal}
 }
 
-func (logger *Logger) kprint(key, value string) {
-	logger.PrintNext(&Line{time.Now(), key, value})
+// Sub returns a child logger which prefixes the keys of all its lines with the
+// given namespace. The child logger shares the pipeline of its parent such that
+// changing the printers of the parent also affects the child.
+func (logger *Logger) Sub(namespace string) *Logger {
+	return &Logger{
+		root:   logger.base(),
+		prefix: logger.key(namespace),
+		fields: logger.fields,
+	}
+}
+
+// With returns a child logger which attaches the given fields to all its lines
+// ahead of the fields provided when printing. The child logger shares the
+// pipeline of its parent such that changing the printers of the parent also
+// affects the child.
+func (logger *Logger) With(fields ...Field) *Logger {
+	child := &Logger{root: logger.base(), prefix: logger.prefix}
+
+	child.fields = make(Fields, 0, len(logger.fields)+len(fields))
+	child.fields = append(child.fields, logger.fields...)
+	child.fields = append(child.fields, fields...)
+
+	return child
+}
+
+// base returns the logger which holds the pipeline used by the logger.
+func (logger *Logger) base() *Logger {
+	if logger.root != nil {
+		return logger.root
+	}
+	return logger
+}
+
+func (logger *Logger) key(key string) string {
+	if len(logger.prefix) == 0 {
+		return key
+	}
+	if len(key) == 0 {
+		return logger.prefix
+	}
+	return logger.prefix + "." + key
+}
+
+func (logger *Logger) line(level Level, key, value string, fields ...Fields) *Line {
+	line := &Line{
+		Timestamp: time.Now(),
+		Key:       logger.key(key),
+		Value:     value,
+		Level:     level,
+	}
+
+	n := len(logger.fields)
+	for _, set := range fields {
+		n += len(set)
+	}
+
+	if n == 0 {
+		return line
+	}
+
+	if n == len(logger.fields) {
+		line.Fields = logger.fields
+		return line
+	}
+
+	line.Fields = make(Fields, 0, n)
+	line.Fields = append(line.Fields, logger.fields...)
+	for _, set := range fields {
+		line.Fields = append(line.Fields, set...)
+	}
+
+	return line
+}
+
+// Enabled returns true if a line with the given key would be printed by the
+// pipeline of the logger. The print functions use this to avoid formatting
+// lines which would be discarded.
+func (logger *Logger) Enabled(key string) bool {
+	return logger.base().EnabledNext(logger.key(key))
+}
+
+func (logger *Logger) kprint(level Level, key string, v []interface{}) {
+	if logger.Enabled(key) {
+		logger.base().PrintNext(logger.line(level, key, fmt.Sprint(v...)))
+	}
+}
+
+func (logger *Logger) kprintf(level Level, key, format string, v []interface{}) {
+	if logger.Enabled(key) {
+		logger.base().PrintNext(logger.line(level, key, fmt.Sprintf(format, v...)))
+	}
+}
+
+func (logger *Logger) kprintw(level Level, key, msg string, fields Fields) {
+	if logger.Enabled(key) {
+		logger.base().PrintNext(logger.line(level, key, msg, fields))
+	}
+}
+
+func (logger *Logger) kprintCtx(ctx context.Context, level Level, key string, v []interface{}) {
+	if logger.Enabled(key) {
+		logger.printCtx(ctx, logger.line(level, key, fmt.Sprint(v...), FieldsFromContext(ctx)))
+	}
+}
+
+func (logger *Logger) kprintfCtx(ctx context.Context, level Level, key, format string, v []interface{}) {
+	if logger.Enabled(key) {
+		logger.printCtx(ctx, logger.line(level, key, fmt.Sprintf(format, v...), FieldsFromContext(ctx)))
+	}
+}
+
+func (logger *Logger) kprintwCtx(ctx context.Context, level Level, key, msg string, fields Fields) {
+	if logger.Enabled(key) {
+		logger.printCtx(ctx, logger.line(level, key, msg, FieldsFromContext(ctx), fields))
+	}
+}
+
+func (logger *Logger) printCtx(ctx context.Context, line *Line) {
+	line.TraceID, line.SpanID = TraceFromContext(ctx)
+	logger.base().PrintNext(line)
 }
 
 // KPrint is similar to log.Print but accepts a key as it's first parameter.
 func (logger *Logger) KPrint(key string, v ...interface{}) {
-	logger.kprint(key, fmt.Sprint(v...))
+	logger.kprint(LevelNone, key, v)
 }
 
 // KPrintf is similar to log.Printf but accepts a key as it's first parameter.
 func (logger *Logger) KPrintf(key, format string, v ...interface{}) {
-	logger.kprint(key, fmt.Sprintf(format, v...))
+	logger.kprintf(LevelNone, key, format, v)
+}
+
+// KPrintw prints the given message along with a set of structured fields which
+// are attached to the line in the order they are provided.
+func (logger *Logger) KPrintw(key, msg string, fields ...Field) {
+	logger.kprintw(LevelNone, key, msg, fields)
+}
+
+// KPrintCtx is similar to KPrint but also attaches the trace id, span id and
+// fields carried by the context to the line.
+func (logger *Logger) KPrintCtx(ctx context.Context, key string, v ...interface{}) {
+	logger.kprintCtx(ctx, LevelNone, key, v)
+}
+
+// KPrintfCtx is similar to KPrintf but also attaches the trace id, span id and
+// fields carried by the context to the line.
+func (logger *Logger) KPrintfCtx(ctx context.Context, key, format string, v ...interface{}) {
+	logger.kprintfCtx(ctx, LevelNone, key, format, v)
+}
+
+// KPrintwCtx is similar to KPrintw but also attaches the trace id, span id and
+// fields carried by the context to the line.
+func (logger *Logger) KPrintwCtx(ctx context.Context, key, msg string, fields ...Field) {
+	logger.kprintwCtx(ctx, LevelNone, key, msg, fields)
+}
+
+// KLogCtx prints the message with the given level along with the trace id,
+// span id and fields carried by the context.
+func (logger *Logger) KLogCtx(ctx context.Context, level Level, key, msg string, fields ...Field) {
+	logger.kprintwCtx(ctx, level, key, msg, fields)
+}
+
+// KDebug is similar to KPrint but marks the line with LevelDebug.
+func (logger *Logger) KDebug(key string, v ...interface{}) {
+	logger.kprint(LevelDebug, key, v)
+}
+
+// KDebugf is similar to KPrintf but marks the line with LevelDebug.
+func (logger *Logger) KDebugf(key, format string, v ...interface{}) {
+	logger.kprintf(LevelDebug, key, format, v)
+}
+
+// KInfo is similar to KPrint but marks the line with LevelInfo.
+func (logger *Logger) KInfo(key string, v ...interface{}) {
+	logger.kprint(LevelInfo, key, v)
+}
+
+// KInfof is similar to KPrintf but marks the line with LevelInfo.
+func (logger *Logger) KInfof(key, format string, v ...interface{}) {
+	logger.kprintf(LevelInfo, key, format, v)
+}
+
+// KWarn is similar to KPrint but marks the line with LevelWarn.
+func (logger *Logger) KWarn(key string, v ...interface{}) {
+	logger.kprint(LevelWarn, key, v)
+}
+
+// KWarnf is similar to KPrintf but marks the line with LevelWarn.
+func (logger *Logger) KWarnf(key, format string, v ...interface{}) {
+	logger.kprintf(LevelWarn, key, format, v)
+}
+
+// KError is similar to KPrint but marks the line with LevelError.
+func (logger *Logger) KError(key string, v ...interface{}) {
+	logger.kprint(LevelError, key, v)
+}
+
+// KErrorf is similar to KPrintf but marks the line with LevelError.
+func (logger *Logger) KErrorf(key, format string, v ...interface{}) {
+	logger.kprintf(LevelError, key, format, v)
+}
+
+// Shutdown flushes and closes the printers of the logger which are shared with
+// its parent if the logger is a child logger. Returns early with
+// the context's error if the context is done before the pipeline is closed.
+func (logger *Logger) Shutdown(ctx context.Context) error {
+	resultC := make(chan error, 1)
+
+	base := logger.base()
+
+	go func() {
+		err := closePrinter(base.Next)
+		if closeErr := closePrinter(base.Fatal); err == nil {
+			err = closeErr
+		}
+		resultC <- err
+	}()
+
+	select {
+	case err := <-resultC:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (logger *Logger) kfatal(key, value string) {
-	line := &Line{time.Now(), key, value}
-	logger.Fatal.Print(line)
+	line := logger.line(LevelFatal, key, value)
+	logger.base().Fatal.Print(line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultFatalTimeout)
+	logger.Shutdown(ctx)
+	cancel()
+
 	os.Exit(1)
 }
 
@@ -50,8 +272,8 @@ func (logger *Logger) KFatalf(key, format string, v ...interface{}) {
 }
 
 func (logger *Logger) kpanic(key, value string) {
-	line := &Line{time.Now(), key, value}
-	logger.Fatal.Print(line)
+	line := logger.line(LevelFatal, key, value)
+	logger.base().Fatal.Print(line)
 	panic(line.String())
 }
 
@@ -74,6 +296,58 @@ func KPrint(key string, v ...interface{}) { logger.KPrint(key, v...) }
 // KPrintf is similar to fmt.Printf but accepts a key as it's first parameter.
 func KPrintf(key, format string, v ...interface{}) { logger.KPrintf(key, format, v...) }
 
+// KPrintw prints the given message along with a set of structured fields.
+func KPrintw(key, msg string, fields ...Field) { logger.KPrintw(key, msg, fields...) }
+
+// KPrintCtx is similar to KPrint but uses the logger carried by the context
+// and attaches the trace id, span id and fields carried by the context.
+func KPrintCtx(ctx context.Context, key string, v ...interface{}) {
+	FromContext(ctx).KPrintCtx(ctx, key, v...)
+}
+
+// KPrintfCtx is similar to KPrintf but uses the logger carried by the context
+// and attaches the trace id, span id and fields carried by the context.
+func KPrintfCtx(ctx context.Context, key, format string, v ...interface{}) {
+	FromContext(ctx).KPrintfCtx(ctx, key, format, v...)
+}
+
+// KPrintwCtx is similar to KPrintw but uses the logger carried by the context
+// and attaches the trace id, span id and fields carried by the context.
+func KPrintwCtx(ctx context.Context, key, msg string, fields ...Field) {
+	FromContext(ctx).KPrintwCtx(ctx, key, msg, fields...)
+}
+
+// KLogCtx prints the message with the given level using the logger carried by
+// the context and attaches the trace id, span id and fields carried by the
+// context.
+func KLogCtx(ctx context.Context, level Level, key, msg string, fields ...Field) {
+	FromContext(ctx).KLogCtx(ctx, level, key, msg, fields...)
+}
+
+// KDebug is similar to KPrint but marks the line with LevelDebug.
+func KDebug(key string, v ...interface{}) { logger.KDebug(key, v...) }
+
+// KDebugf is similar to KPrintf but marks the line with LevelDebug.
+func KDebugf(key, format string, v ...interface{}) { logger.KDebugf(key, format, v...) }
+
+// KInfo is similar to KPrint but marks the line with LevelInfo.
+func KInfo(key string, v ...interface{}) { logger.KInfo(key, v...) }
+
+// KInfof is similar to KPrintf but marks the line with LevelInfo.
+func KInfof(key, format string, v ...interface{}) { logger.KInfof(key, format, v...) }
+
+// KWarn is similar to KPrint but marks the line with LevelWarn.
+func KWarn(key string, v ...interface{}) { logger.KWarn(key, v...) }
+
+// KWarnf is similar to KPrintf but marks the line with LevelWarn.
+func KWarnf(key, format string, v ...interface{}) { logger.KWarnf(key, format, v...) }
+
+// KError is similar to KPrint but marks the line with LevelError.
+func KError(key string, v ...interface{}) { logger.KError(key, v...) }
+
+// KErrorf is similar to KPrintf but marks the line with LevelError.
+func KErrorf(key, format string, v ...interface{}) { logger.KErrorf(key, format, v...) }
+
 // KFatal is similar to fmt.Fatal but accepts a key as it's first parameter.
 func KFatal(key string, v ...interface{}) { logger.KFatal(key, v...) }
 
@@ -86,6 +360,23 @@ func KPanic(key string, v ...interface{}) { logger.KPanic(key, v...) }
 // KPanicf is similar to fmt.Panicf but accepts a key as it's first parameter.
 func KPanicf(key, format string, v ...interface{}) { logger.KPanicf(key, format, v...) }
 
+// Shutdown flushes and closes the global printers used by the global klog print
+// functions. Should be called before the program exits to avoid losing any
+// lines buffered in the pipeline.
+func Shutdown(ctx context.Context) error { return logger.Shutdown(ctx) }
+
+// Enabled returns true if a line with the given key would be printed by the
+// global pipeline.
+func Enabled(key string) bool { return logger.Enabled(key) }
+
+// Sub returns a child of the global logger which prefixes the keys of all its
+// lines with the given namespace.
+func Sub(namespace string) *Logger { return logger.Sub(namespace) }
+
+// With returns a child of the global logger which attaches the given fields to
+// all its lines.
+func With(fields ...Field) *Logger { return logger.With(fields...) }
+
 // GetPrinter returns the global printer used by the global KPrint and KPrintf
 // function.
 func GetPrinter() Printer { return logger.Next }
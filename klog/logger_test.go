@@ -0,0 +1,84 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLogger_Sub(t *testing.T) {
+	ring := NewRing(10)
+	logger := New(NilPrinter, NilPrinter)
+
+	http := logger.Sub("http").With(F("a", 1))
+	server := http.Sub("server").With(F("b", 2))
+
+	// Children share the pipeline of their parent.
+	logger.Chain(ring)
+
+	logger.KPrint("x", "0")
+	http.KPrint("x", "1")
+	server.KPrintw("x", "2", F("c", 3))
+	server.KPrintwCtx(WithFields(context.Background(), F("d", 4)), "", "3", F("c", 3))
+
+	lines := ring.GetAll()
+	ExpectOrdered(t, Simplify(lines),
+		"<x> 0",
+		"<http.x> 1",
+		"<http.server.x> 2",
+		"<http.server> 3",
+	)
+
+	if len(lines) != 4 {
+		t.FailNow()
+	}
+
+	for i, exp := range []string{"", "a=1", "a=1 b=2 c=3", "a=1 b=2 d=4 c=3"} {
+		if result := lines[i].Fields.String(); result != exp {
+			t.Errorf("FAIL: %d: '%s' != '%s'", i, result, exp)
+		}
+	}
+}
+
+type CountStringer struct{ Count int }
+
+func (stringer *CountStringer) String() string {
+	stringer.Count++
+	return "x"
+}
+
+func TestLogger_Enabled(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := NewFilter(FilterOut)
+	logger := New(Chain(filter, Fork(NewLevelFilter(LevelInfo), out)), NilPrinter)
+
+	stringer := &CountStringer{}
+
+	filter.AddSuffix("debug")
+
+	if logger.Enabled("a.debug") {
+		t.Error("FAIL: a.debug should be disabled")
+	}
+
+	logger.KPrint("a.debug", stringer)
+	logger.KPrintf("a.debug", "%s", stringer)
+	logger.Sub("a").KPrintCtx(context.Background(), "debug", stringer)
+
+	if stringer.Count != 0 {
+		t.Errorf("FAIL: disabled lines were formatted %d times", stringer.Count)
+	}
+
+	logger.KPrint("a.info", stringer)
+	out.ExpectOrdered("<a.info> x")
+
+	filter.RemoveSuffix("debug")
+
+	if !logger.Sub("a").Enabled("debug") {
+		t.Error("FAIL: a.debug should be enabled")
+	}
+
+	if New(nil, NilPrinter).Enabled("a") {
+		t.Error("FAIL: logger without printers should be disabled")
+	}
+}
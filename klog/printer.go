@@ -3,10 +3,12 @@
 package klog
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
 // Printer represents a stage in the printing pipeline.
@@ -14,6 +16,28 @@ type Printer interface {
 	Print(*Line)
 }
 
+// Enabler is implemented by printers which can tell ahead of time whether a
+// line with the given key would be printed. This allows the work of formatting
+// a line to be skipped if it would be discarded.
+type Enabler interface {
+	Enabled(key string) bool
+}
+
+// Flusher is implemented by printers which buffer lines before outputting
+// them. Chained printers should propagate the call to the rest of the pipeline
+// after flushing their own buffers.
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is implemented by printers which hold resources that must be released
+// such as background goroutines or files. Closing a printer should flush any
+// buffered lines and chained printers should propagate the call to the rest of
+// the pipeline. Closing a printer more then once should be a noop.
+type Closer interface {
+	Close() error
+}
+
 // PrinterFunc implements the Printer interface for functions.
 type PrinterFunc func(*Line)
 
@@ -31,7 +55,7 @@ var DefaultPrinter = PrinterFunc(LogPrinter)
 var DefaultFatalPrinter = PrinterFunc(LogPrinter)
 
 // LogPrinter is forwards all lines to the golang standard log library.
-func LogPrinter(line *Line) { log.Printf("<%s> %s", line.Key, line.Value) }
+func LogPrinter(line *Line) { log.Print(line.text()) }
 
 // Keyf is a utility formatting functions for key and is a light wrapper around
 // fmt.Sprintf.
@@ -40,29 +64,62 @@ func Keyf(format string, args ...interface{}) string {
 }
 
 // JsonPrinter forwards all lines to the golang standard log library
-// in a json format
+// in a json format. Fields attached to the line are emitted as members of the
+// json object where fields which collide with the members of the line are
+// prefixed by ReservedFieldPrefix. Lines without a level fallback to the legacy
+// behaviour of using the last segment of the key as the level.
 func JsonPrinter(line *Line) {
-	split := strings.Split(line.Key, ".")
-	var level string
-	if len(split) > 0 {
-		level = split[len(split)-1]
-	}
-	line.Key = strings.Join(split[:len(split)-1], ".")
+	key, level := line.Key, line.Level.String()
 
-	sLine := struct {
-		*Line
-		Level string `json:"level"`
-	}{
-		Line:  line,
-		Level: level,
+	if line.Level == LevelNone {
+		split := strings.Split(line.Key, ".")
+		if len(split) > 0 {
+			level = split[len(split)-1]
+		}
+		key = strings.Join(split[:len(split)-1], ".")
 	}
 
-	if js, err := json.Marshal(sLine); err != nil {
+	if js, err := marshalLine(line, key, level); err != nil {
 		log.Printf("line json marshal error: %s", err)
 	} else {
 		log.Printf("@cee: %s", js)
 	}
 }
 
+// lineKeys are the members of the json object of a line which can't be
+// overridden by the fields of the line.
+var lineKeys = map[string]bool{
+	"ts": true, "key": true, "val": true, "level": true, "trace": true, "span": true,
+}
+
+func marshalLine(line *Line, key, level string) ([]byte, error) {
+	sLine := struct {
+		Timestamp time.Time `json:"ts"`
+		Key       string    `json:"key"`
+		Value     string    `json:"val"`
+		Level     string    `json:"level,omitempty"`
+		TraceID   string    `json:"trace,omitempty"`
+		SpanID    string    `json:"span,omitempty"`
+	}{
+		Timestamp: line.Timestamp,
+		Key:       key,
+		Value:     line.Value,
+		Level:     level,
+		TraceID:   line.TraceID,
+		SpanID:    line.SpanID,
+	}
+
+	js, err := json.Marshal(sLine)
+	if err != nil || len(line.Fields) == 0 {
+		return js, err
+	}
+
+	buffer := bytes.NewBuffer(js[:len(js)-1])
+	line.Fields.escape(lineKeys).appendJSON(buffer, false)
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
 // Structured printer if a JsonPrinter
 var StructuredPrinter = PrinterFunc(JsonPrinter)
@@ -0,0 +1,313 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitInterval is used when RateLimit.Interval is left empty.
+const DefaultRateLimitInterval = 10 * time.Second
+
+// RateLimitKey is the key of the summary lines of the bucket shared by all keys
+// when the rule with an empty prefix isn't maintained per key.
+const RateLimitKey = "klog.ratelimit"
+
+// RateLimitRule limits the number of lines printed for the keys starting with
+// a given prefix using a token bucket. The bucket can either be shared by all
+// the keys matching the prefix or be maintained separately for each key.
+type RateLimitRule struct {
+	// Prefix is matched against the start of the key. An empty prefix matches
+	// all keys.
+	Prefix string `json:"prefix"`
+
+	// Rate is the number of lines per second allowed through the bucket. Must
+	// be greater then 0.
+	Rate float64 `json:"rate"`
+
+	// Burst is the number of lines that can be printed in a single burst.
+	// Defaults to the rate rounded up with a minimum of 1.
+	Burst int `json:"burst,omitempty"`
+
+	// PerKey indicates whether each key matching the prefix has its own bucket
+	// instead of sharing a single bucket for the prefix.
+	PerKey bool `json:"perKey,omitempty"`
+}
+
+func (rule *RateLimitRule) validate() error {
+	if rule.Rate < 0 || rule.Burst < 0 {
+		return fmt.Errorf("invalid rate limit for '%s'", rule.Prefix)
+	}
+
+	if rule.Rate == 0 {
+		return fmt.Errorf("rate limit for '%s' never refills: rate must be set", rule.Prefix)
+	}
+
+	if rule.Burst == 0 {
+		rule.Burst = int(math.Max(1, math.Ceil(rule.Rate)))
+	}
+
+	return nil
+}
+
+type rateLimitBucket struct {
+	rule       *RateLimitRule
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+func (bucket *rateLimitBucket) take(now time.Time) bool {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.last = now
+
+	if elapsed > 0 {
+		bucket.tokens = math.Min(float64(bucket.rule.Burst), bucket.tokens+elapsed*bucket.rule.Rate)
+	}
+
+	if bucket.tokens < 1 {
+		bucket.suppressed++
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// RateLimit suppresses the lines of keys which exceed their budget. Budgets are
+// defined by rules where the rule with the longest prefix matching the key of
+// a line is used and lines which don't match any rules are never suppressed.
+// Prefixes are indexed in a trie so the cost of finding the rule depends on the
+// depth of the key rather then the number of rules. A single summary line is
+// printed for each suppressed key or prefix at the end of every interval with
+// the number of lines that were suppressed.
+type RateLimit struct {
+	Chained
+
+	// Rules is the initial set of rules.
+	Rules []RateLimitRule
+
+	// Interval is the rate at which the summary of suppressed lines are
+	// printed. Defaults to DefaultRateLimitInterval.
+	Interval time.Duration
+
+	initialize sync.Once
+
+	mutex   sync.Mutex
+	rules   map[string]*RateLimitRule
+	index   *keyTrie
+	buckets map[string]*rateLimitBucket
+
+	stopC chan struct{}
+	doneC chan struct{}
+	stop  sync.Once
+}
+
+// NewRateLimit creates a new RateLimit printer with the given rules.
+func NewRateLimit(rules ...RateLimitRule) *RateLimit { return &RateLimit{Rules: rules} }
+
+// Init initializes the object. Calling this is optional since the object will
+// lazily initialize itself when needed.
+func (limit *RateLimit) Init() {
+	limit.initialize.Do(limit.init)
+}
+
+func (limit *RateLimit) init() {
+	if limit.Interval == 0 {
+		limit.Interval = DefaultRateLimitInterval
+	}
+
+	limit.rules = make(map[string]*RateLimitRule)
+	limit.buckets = make(map[string]*rateLimitBucket)
+
+	for i := range limit.Rules {
+		rule := limit.Rules[i]
+		if err := rule.validate(); err != nil {
+			log.Panic(err)
+		}
+		limit.rules[rule.Prefix] = &rule
+	}
+	limit.reindex()
+
+	limit.stopC = make(chan struct{})
+	limit.doneC = make(chan struct{})
+
+	go limit.run()
+}
+
+// SetRule adds the given rule or replaces the rule with the same prefix. The
+// buckets of the replaced rule are reset.
+func (limit *RateLimit) SetRule(rule RateLimitRule) error {
+	limit.Init()
+
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	limit.rules[rule.Prefix] = &rule
+	limit.reindex()
+	return nil
+}
+
+// RemoveRule removes the rule with the given prefix.
+func (limit *RateLimit) RemoveRule(prefix string) {
+	limit.Init()
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	delete(limit.rules, prefix)
+	limit.reindex()
+}
+
+// reindex rebuilds the trie used to find the longest prefix matching a key.
+// Must be called with the lock held.
+func (limit *RateLimit) reindex() {
+	limit.index = newKeyTrie()
+	for prefix := range limit.rules {
+		limit.index.insertPrefix(prefix)
+	}
+}
+
+// GetRules returns the list of rules ordered by prefix.
+func (limit *RateLimit) GetRules() []RateLimitRule {
+	limit.Init()
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	result := make([]RateLimitRule, 0, len(limit.rules))
+	for _, rule := range limit.rules {
+		result = append(result, *rule)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Prefix < result[j].Prefix })
+	return result
+}
+
+// Print forwards the line to the next printer unless the budget of its key is
+// exhausted in which case the line is counted and discarded.
+func (limit *RateLimit) Print(line *Line) {
+	limit.Init()
+
+	if limit.allow(line.Key) {
+		limit.PrintNext(line)
+	}
+}
+
+func (limit *RateLimit) allow(key string) bool {
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	prefix, ok := limit.index.matchLongestPrefix(key)
+	if !ok {
+		return true
+	}
+	rule := limit.rules[prefix]
+
+	id := rule.Prefix
+	if rule.PerKey {
+		id = key
+	}
+
+	now := time.Now()
+
+	bucket, ok := limit.buckets[id]
+	if !ok || bucket.rule != rule {
+		fresh := &rateLimitBucket{rule: rule, tokens: float64(rule.Burst), last: now}
+		if ok {
+			fresh.suppressed = bucket.suppressed
+		}
+
+		bucket = fresh
+		limit.buckets[id] = bucket
+	}
+
+	return bucket.take(now)
+}
+
+// report prints a summary line for each key or prefix with suppressed lines.
+// Buckets which are full are discarded since they are equivalent to a new
+// bucket.
+func (limit *RateLimit) report() {
+	now := time.Now()
+	var lines []*Line
+
+	limit.mutex.Lock()
+
+	for id, bucket := range limit.buckets {
+		if bucket.suppressed > 0 {
+			key := id
+			if len(key) == 0 {
+				key = RateLimitKey
+			}
+
+			lines = append(lines, &Line{
+				Timestamp: now,
+				Key:       key,
+				Value:     fmt.Sprintf("%d lines suppressed for key %s", bucket.suppressed, key),
+				Fields:    Fields{F("suppressed", bucket.suppressed)},
+			})
+			bucket.suppressed = 0
+		}
+
+		elapsed := now.Sub(bucket.last).Seconds()
+		if bucket.tokens+elapsed*bucket.rule.Rate >= float64(bucket.rule.Burst) {
+			delete(limit.buckets, id)
+		}
+	}
+
+	limit.mutex.Unlock()
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Key < lines[j].Key })
+
+	for _, line := range lines {
+		limit.PrintNext(line)
+	}
+}
+
+// Flush prints the summary of suppressed lines before flushing the rest of the
+// pipeline.
+func (limit *RateLimit) Flush() error {
+	limit.Init()
+
+	limit.report()
+	return limit.FlushNext()
+}
+
+// Close prints the summary of suppressed lines and stops the background
+// goroutine before closing the rest of the pipeline.
+func (limit *RateLimit) Close() error {
+	limit.Init()
+
+	limit.stop.Do(func() { close(limit.stopC) })
+	<-limit.doneC
+
+	limit.report()
+	return limit.CloseNext()
+}
+
+func (limit *RateLimit) run() {
+	defer close(limit.doneC)
+
+	ticker := time.NewTicker(limit.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			limit.report()
+
+		case <-limit.stopC:
+			return
+		}
+	}
+}
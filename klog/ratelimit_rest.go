@@ -0,0 +1,57 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"github.com/datacratic/gorest/rest"
+)
+
+// RateLimitREST provides the REST interface for the RateLimit chained printer.
+type RateLimitREST struct {
+	*RateLimit
+
+	// PathPrefix will be pre-pended to all the REST paths. Defaults to
+	// DefaultPathREST.
+	PathPrefix string
+}
+
+// NewRateLimitREST creates a new REST enabled RateLimit chained printer at the
+// specified path with the given initial rules.
+func NewRateLimitREST(path string, rules ...RateLimitRule) *RateLimitREST {
+	limit := &RateLimitREST{RateLimit: NewRateLimit(rules...), PathPrefix: path}
+	rest.AddService(limit)
+	return limit
+}
+
+// RESTRoutes returns the set of gorest routes used to manipulate the RateLimit
+// chained printer. Rules are passed as a json RateLimitRule object in the body
+// of the request whose prefix is taken from the path. The default rule which
+// matches all keys is addressed through the default route.
+func (limit *RateLimitREST) RESTRoutes() rest.Routes {
+	prefix := limit.PathPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultPathREST + "/ratelimit"
+	}
+
+	return []*rest.Route{
+		rest.NewRoute(prefix, "GET", limit.GetRules),
+
+		rest.NewRoute(prefix+"/prefix/:prefix", "PUT", limit.setRule),
+		rest.NewRoute(prefix+"/prefix/:prefix", "DELETE", limit.RemoveRule),
+
+		rest.NewRoute(prefix+"/default", "PUT", limit.setDefault),
+		rest.NewRoute(prefix+"/default", "DELETE", limit.removeDefault),
+	}
+}
+
+func (limit *RateLimitREST) setRule(prefix string, rule RateLimitRule) error {
+	rule.Prefix = prefix
+	return limit.SetRule(rule)
+}
+
+func (limit *RateLimitREST) setDefault(rule RateLimitRule) error {
+	rule.Prefix = ""
+	return limit.SetRule(rule)
+}
+
+func (limit *RateLimitREST) removeDefault() { limit.RemoveRule("") }
@@ -0,0 +1,88 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	out := &TestPrinter{T: t}
+	limit := NewRateLimit(
+		RateLimitRule{Prefix: "a", Rate: 0.001, Burst: 2},
+		RateLimitRule{Prefix: "a.b", Rate: 0.001, Burst: 1, PerKey: true},
+	)
+	limit.Interval = time.Hour
+	limit.Chain(out)
+
+	for i := 0; i < 3; i++ {
+		limit.Print(L("a.a", "x"))
+		limit.Print(L("a.c", "x"))
+		limit.Print(L("a.b.a", "x"))
+		limit.Print(L("a.b.c", "x"))
+		limit.Print(L("b", "x"))
+	}
+
+	out.ExpectOrdered(
+		"<a.a> x",
+		"<a.c> x",
+		"<a.b.a> x",
+		"<a.b.c> x",
+		"<b> x",
+		"<b> x",
+		"<b> x",
+	)
+
+	limit.Flush()
+	out.ExpectOrdered(
+		"<a> 4 lines suppressed for key a",
+		"<a.b.a> 2 lines suppressed for key a.b.a",
+		"<a.b.c> 2 lines suppressed for key a.b.c",
+	)
+
+	limit.Flush()
+	out.ExpectOrdered()
+
+	limit.Print(L("a.a", "x"))
+	limit.Close()
+
+	out.ExpectOrdered("<a> 1 lines suppressed for key a")
+}
+
+func TestRateLimit_Rules(t *testing.T) {
+	out := &TestPrinter{T: t}
+	limit := &RateLimit{Interval: time.Hour}
+	limit.Chain(out)
+
+	if err := limit.SetRule(RateLimitRule{Prefix: "a", Rate: -1}); err == nil {
+		t.Error("FAIL: expected error on invalid rate")
+	}
+
+	if err := limit.SetRule(RateLimitRule{Prefix: "a", Rate: 0, Burst: 10}); err == nil {
+		t.Error("FAIL: expected error on rule without rate")
+	}
+
+	limit.SetRule(RateLimitRule{Rate: 0.001})
+	limit.SetRule(RateLimitRule{Prefix: "a", Rate: 1000})
+
+	if rules := limit.GetRules(); len(rules) != 2 || rules[0].Prefix != "" || rules[1].Burst != 1000 {
+		t.Errorf("FAIL: unexpected rules %v", rules)
+	}
+
+	limit.Print(L("a", "x"))
+	limit.Print(L("b", "x"))
+	limit.Print(L("b", "x"))
+
+	limit.RemoveRule("")
+	limit.Print(L("b", "x"))
+
+	limit.Close()
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<b> x",
+		"<b> x",
+		"<klog.ratelimit> 1 lines suppressed for key klog.ratelimit",
+	)
+}
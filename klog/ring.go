@@ -77,6 +77,13 @@ func (ring *Ring) GetSuffix(suffix string) []*Line {
 	})
 }
 
+// GetTrace returns all the lines in the ring with the given trace id sorted by
+// their timestamp.
+func (ring *Ring) GetTrace(traceID string) []*Line {
+	ring.Init()
+	return ring.get(func(line *Line) bool { return line.TraceID == traceID })
+}
+
 // Print adds the given line to the ring overwritting any older line present.
 func (ring *Ring) Print(line *Line) {
 	ring.Init()
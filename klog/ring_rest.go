@@ -37,5 +37,6 @@ func (ring *RingREST) RESTRoutes() rest.Routes {
 		rest.NewRoute(prefix+"/key/:key", "GET", ring.GetKey),
 		rest.NewRoute(prefix+"/prefix/:prefix", "GET", ring.GetPrefix),
 		rest.NewRoute(prefix+"/suffix/:suffix", "GET", ring.GetSuffix),
+		rest.NewRoute(prefix+"/trace/:trace", "GET", ring.GetTrace),
 	}
 }
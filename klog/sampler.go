@@ -0,0 +1,311 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSamplerInterval is used when Sampler.Interval is left empty.
+const DefaultSamplerInterval = 10 * time.Second
+
+// SamplerRule determines the fraction of lines kept for the keys matching a
+// glob pattern. The first lines of each key within an interval are always kept
+// after which either 1 in every Every lines is kept or, if Every is 0, lines
+// are kept randomly with a probability of Rate. Rules which set neither Rate
+// nor Every drop all lines and can't set First.
+type SamplerRule struct {
+	// Pattern is a glob matched against the "."-separated segments of the key
+	// where "*" matches any single segment and "**" matches zero or more
+	// segments.
+	Pattern string `json:"pattern"`
+
+	// Rate is the probability between 0 and 1 of keeping a line.
+	Rate float64 `json:"rate,omitempty"`
+
+	// First is the number of lines of each key which are kept at the start of
+	// every interval.
+	First int `json:"first,omitempty"`
+
+	// Every keeps 1 line in every Every lines once First lines were kept. Takes
+	// precedence over Rate if set.
+	Every int `json:"every,omitempty"`
+}
+
+func (rule *SamplerRule) validate() error {
+	if rule.Rate < 0 || rule.Rate > 1 {
+		return fmt.Errorf("invalid sampler rate '%f' for '%s'", rule.Rate, rule.Pattern)
+	}
+
+	if rule.First < 0 || rule.Every < 0 {
+		return fmt.Errorf("invalid sampler counts for '%s'", rule.Pattern)
+	}
+
+	if rule.First > 0 && rule.Rate == 0 && rule.Every == 0 {
+		return fmt.Errorf("sampler rule for '%s' drops all lines after the first %d: rate or every must be set",
+			rule.Pattern, rule.First)
+	}
+
+	return nil
+}
+
+type samplerRule struct {
+	SamplerRule
+	glob keyGlob
+}
+
+type samplerKey struct {
+	seen    atomic.Int64
+	dropped atomic.Int64
+}
+
+// Sampler keeps a fraction of the lines whose key matches one of its rules and
+// discards the rest. Rules are evaluated in order and the first rule matching
+// the key of a line is used while lines which don't match any rules are always
+// kept. The number of lines dropped for each key is reported at the end of
+// every interval through a line printed with the same key.
+//
+// The rules are published as an immutable snapshot and the state of each key is
+// updated atomically so lines can be sampled concurrently.
+type Sampler struct {
+	Chained
+
+	// Rules is the initial list of sampling rules.
+	Rules []SamplerRule
+
+	// Interval is the period over which the First lines of each key are kept
+	// and at which dropped lines are reported. Defaults to
+	// DefaultSamplerInterval.
+	Interval time.Duration
+
+	initialize sync.Once
+
+	mutex sync.Mutex
+	rules atomic.Value
+
+	// keysLock is only held exclusively when the interval is restarted such that
+	// no updates to the keys are lost.
+	keysLock sync.RWMutex
+	keys     *sync.Map
+
+	stopC chan struct{}
+	doneC chan struct{}
+	stop  sync.Once
+}
+
+// NewSampler creates a new Sampler printer with the given rules.
+func NewSampler(rules ...SamplerRule) *Sampler { return &Sampler{Rules: rules} }
+
+// Init initializes the object. Calling this is optional since the object will
+// lazily initialize itself when needed.
+func (sampler *Sampler) Init() {
+	sampler.initialize.Do(sampler.init)
+}
+
+func (sampler *Sampler) init() {
+	if sampler.Interval == 0 {
+		sampler.Interval = DefaultSamplerInterval
+	}
+
+	var rules []*samplerRule
+	for _, rule := range sampler.Rules {
+		if err := rule.validate(); err != nil {
+			log.Panic(err)
+		}
+		rules = append(rules, &samplerRule{rule, newKeyGlob(rule.Pattern)})
+	}
+
+	sampler.rules.Store(rules)
+	sampler.keys = new(sync.Map)
+
+	sampler.stopC = make(chan struct{})
+	sampler.doneC = make(chan struct{})
+
+	go sampler.run()
+}
+
+func (sampler *Sampler) load() []*samplerRule {
+	return sampler.rules.Load().([]*samplerRule)
+}
+
+// SetRule adds the given rule or replaces the rule with the same pattern. New
+// rules are evaluated after all the existing rules.
+func (sampler *Sampler) SetRule(rule SamplerRule) error {
+	sampler.Init()
+
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+
+	rules := append([]*samplerRule(nil), sampler.load()...)
+	replacement := &samplerRule{rule, newKeyGlob(rule.Pattern)}
+
+	for i, other := range rules {
+		if other.Pattern == rule.Pattern {
+			rules[i] = replacement
+			sampler.rules.Store(rules)
+			return nil
+		}
+	}
+
+	sampler.rules.Store(append(rules, replacement))
+	return nil
+}
+
+// RemoveRule removes the rule with the given pattern.
+func (sampler *Sampler) RemoveRule(pattern string) {
+	sampler.Init()
+
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+
+	rules := sampler.load()
+
+	for i, rule := range rules {
+		if rule.Pattern == pattern {
+			sampler.rules.Store(append(rules[:i:i], rules[i+1:]...))
+			return
+		}
+	}
+}
+
+// GetRules returns the list of rules in evaluation order.
+func (sampler *Sampler) GetRules() []SamplerRule {
+	sampler.Init()
+
+	rules := sampler.load()
+
+	result := make([]SamplerRule, len(rules))
+	for i, rule := range rules {
+		result[i] = rule.SamplerRule
+	}
+	return result
+}
+
+// Print forwards the line to the next printer if it's selected by the first
+// rule matching its key or if no rules match its key.
+func (sampler *Sampler) Print(line *Line) {
+	sampler.Init()
+
+	if sampler.keep(line.Key) {
+		sampler.PrintNext(line)
+	}
+}
+
+func (sampler *Sampler) keep(key string) bool {
+	var rule *samplerRule
+	for _, candidate := range sampler.load() {
+		if candidate.glob.match(key) {
+			rule = candidate
+			break
+		}
+	}
+
+	if rule == nil {
+		return true
+	}
+
+	sampler.keysLock.RLock()
+	defer sampler.keysLock.RUnlock()
+
+	value, ok := sampler.keys.Load(key)
+	if !ok {
+		value, _ = sampler.keys.LoadOrStore(key, new(samplerKey))
+	}
+	state := value.(*samplerKey)
+
+	var keep bool
+	if n := int(state.seen.Add(1)) - rule.First; n <= 0 {
+		keep = true
+	} else if rule.Every > 0 {
+		keep = (n-1)%rule.Every == 0
+	} else {
+		keep = rand.Float64() < rule.Rate
+	}
+
+	if !keep {
+		state.dropped.Add(1)
+	}
+
+	return keep
+}
+
+// report prints the number of dropped lines for each key. If reset is set
+// then the interval is restarted for all keys.
+func (sampler *Sampler) report(reset bool) {
+	now := time.Now()
+	var lines []*Line
+
+	sampler.keysLock.Lock()
+
+	sampler.keys.Range(func(key, value interface{}) bool {
+		if dropped := value.(*samplerKey).dropped.Swap(0); dropped > 0 {
+			lines = append(lines, &Line{
+				Timestamp: now,
+				Key:       key.(string),
+				Value:     fmt.Sprintf("[%d lines sampled out]", dropped),
+				Fields:    Fields{F("dropped", int(dropped))},
+			})
+		}
+		return true
+	})
+
+	if reset {
+		sampler.keys = new(sync.Map)
+	}
+
+	sampler.keysLock.Unlock()
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Key < lines[j].Key })
+
+	for _, line := range lines {
+		sampler.PrintNext(line)
+	}
+}
+
+// Flush reports the number of dropped lines before flushing the rest of the
+// pipeline.
+func (sampler *Sampler) Flush() error {
+	sampler.Init()
+
+	sampler.report(false)
+	return sampler.FlushNext()
+}
+
+// Close reports the number of dropped lines and stops the background goroutine
+// before closing the rest of the pipeline.
+func (sampler *Sampler) Close() error {
+	sampler.Init()
+
+	sampler.stop.Do(func() { close(sampler.stopC) })
+	<-sampler.doneC
+
+	sampler.report(true)
+	return sampler.CloseNext()
+}
+
+func (sampler *Sampler) run() {
+	defer close(sampler.doneC)
+
+	ticker := time.NewTicker(sampler.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sampler.report(true)
+
+		case <-sampler.stopC:
+			return
+		}
+	}
+}
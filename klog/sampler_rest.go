@@ -0,0 +1,45 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"github.com/datacratic/gorest/rest"
+)
+
+// SamplerREST provides the REST interface for the Sampler chained printer.
+type SamplerREST struct {
+	*Sampler
+
+	// PathPrefix will be pre-pended to all the REST paths. Defaults to
+	// DefaultPathREST.
+	PathPrefix string
+}
+
+// NewSamplerREST creates a new REST enabled Sampler chained printer at the
+// specified path with the given initial rules.
+func NewSamplerREST(path string, rules ...SamplerRule) *SamplerREST {
+	sampler := &SamplerREST{Sampler: NewSampler(rules...), PathPrefix: path}
+	rest.AddService(sampler)
+	return sampler
+}
+
+// RESTRoutes returns the set of gorest routes used to manipulate the Sampler
+// chained printer. Rules are passed as a json SamplerRule object in the body of
+// the request whose pattern is taken from the path.
+func (sampler *SamplerREST) RESTRoutes() rest.Routes {
+	prefix := sampler.PathPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultPathREST + "/sampler"
+	}
+
+	return []*rest.Route{
+		rest.NewRoute(prefix, "GET", sampler.GetRules),
+		rest.NewRoute(prefix+"/glob/:pattern", "PUT", sampler.setRule),
+		rest.NewRoute(prefix+"/glob/:pattern", "DELETE", sampler.RemoveRule),
+	}
+}
+
+func (sampler *SamplerREST) setRule(pattern string, rule SamplerRule) error {
+	rule.Pattern = pattern
+	return sampler.SetRule(rule)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	out := &TestPrinter{T: t}
+	sampler := NewSampler(
+		SamplerRule{Pattern: "a.*", First: 2, Every: 3},
+		SamplerRule{Pattern: "b.**", Rate: 0},
+		SamplerRule{Pattern: "c", Rate: 1},
+	)
+	sampler.Interval = time.Hour
+	sampler.Chain(out)
+
+	for i := 0; i < 8; i++ {
+		sampler.Print(L("a.a", "x"))
+	}
+
+	sampler.Print(L("a", "x"))
+	sampler.Print(L("b.a.b", "x"))
+	sampler.Print(L("b", "x"))
+	sampler.Print(L("c", "x"))
+	sampler.Print(L("d", "x"))
+
+	out.ExpectOrdered(
+		"<a.a> x", "<a.a> x", // first
+		"<a.a> x", "<a.a> x", // 1 in 3
+		"<a> x",
+		"<c> x",
+		"<d> x",
+	)
+
+	sampler.Flush()
+	out.ExpectOrdered(
+		"<a.a> [4 lines sampled out]",
+		"<b> [1 lines sampled out]",
+		"<b.a.b> [1 lines sampled out]",
+	)
+
+	sampler.Flush()
+	out.ExpectOrdered()
+
+	// The interval isn't over so the first lines were already consumed.
+	sampler.Print(L("a.a", "x"))
+	sampler.Print(L("a.a", "x"))
+	sampler.Close()
+
+	out.ExpectOrdered("<a.a> x", "<a.a> [1 lines sampled out]")
+}
+
+func TestSampler_Rules(t *testing.T) {
+	out := &TestPrinter{T: t}
+	sampler := &Sampler{Interval: 10 * time.Millisecond}
+	sampler.Chain(out)
+
+	if err := sampler.SetRule(SamplerRule{Pattern: "a", Rate: 2}); err == nil {
+		t.Error("FAIL: expected error on invalid rate")
+	}
+
+	sampler.SetRule(SamplerRule{Pattern: "a", Every: 2})
+	sampler.SetRule(SamplerRule{Pattern: "b", Rate: 0})
+	sampler.SetRule(SamplerRule{Pattern: "a", First: 1, Every: 2})
+
+	if err := sampler.SetRule(SamplerRule{Pattern: "a", First: 1}); err == nil {
+		t.Error("FAIL: expected error on rule without rate")
+	}
+
+	if rules := sampler.GetRules(); len(rules) != 2 || rules[0].First != 1 || rules[0].Every != 2 {
+		t.Errorf("FAIL: unexpected rules %v", rules)
+	}
+
+	sampler.Print(L("a", "x"))
+	sampler.Print(L("a", "x"))
+	sampler.Print(L("a", "x"))
+	sampler.RemoveRule("b")
+	sampler.Print(L("b", "x"))
+
+	time.Sleep(50 * time.Millisecond)
+
+	// The interval is over so the first line is kept again.
+	sampler.Print(L("a", "x"))
+	sampler.Close()
+
+	out.ExpectOrdered(
+		"<a> x",
+		"<a> x",
+		"<b> x",
+		"<a> [1 lines sampled out]",
+		"<a> x",
+	)
+}
+
+func TestSampler_Concurrent(t *testing.T) {
+	var kept atomic.Int64
+
+	sampler := NewSampler(SamplerRule{Pattern: "a", Every: 2})
+	sampler.Interval = time.Hour
+	sampler.Chain(PrinterFunc(func(line *Line) {
+		if line.Value == "x" {
+			kept.Add(1)
+		}
+	}))
+
+	var wait sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 1000; j++ {
+				sampler.Print(L("a", "x"))
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	if n := kept.Load(); n != 2000 {
+		t.Errorf("FAIL: expected 2000 lines kept got %d", n)
+	}
+
+	out := &TestPrinter{T: t}
+	sampler.Chain(out)
+	sampler.Close()
+
+	out.ExpectOrdered("<a> [2000 lines sampled out]")
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogKeyAttr is the name of the attribute used by SlogPrinter to hold the key
+// of the line.
+const SlogKeyAttr = "key"
+
+// SlogTraceAttr is the name of the attribute used by SlogPrinter to hold the
+// trace id of the line.
+const SlogTraceAttr = "trace"
+
+// SlogSpanAttr is the name of the attribute used by SlogPrinter to hold the
+// span id of the line.
+const SlogSpanAttr = "span"
+
+// DefaultSlogKey is the key used by SlogHandler for records logged outside of
+// any group if SlogHandler.Key is empty.
+const DefaultSlogKey = "slog"
+
+// SlogHandler implements the slog.Handler interface by converting records into
+// lines which are then forwarded to a klog printer. The groups opened via
+// WithGroup are appended to the handler's key to form the key of the lines.
+type SlogHandler struct {
+	// Next is the printer which will receive the converted lines.
+	Next Printer
+
+	// Key is the key used for records logged outside of any group. Defaults to
+	// DefaultSlogKey.
+	Key string
+
+	// Level is the minimum level of the records to be handled. If nil then all
+	// records are handled.
+	Level slog.Leveler
+
+	fields Fields
+}
+
+// NewSlogHandler creates a new slog handler which forwards all records to the
+// given printer using the given key.
+func NewSlogHandler(next Printer, key string) *SlogHandler {
+	return &SlogHandler{Next: next, Key: key}
+}
+
+func (handler *SlogHandler) key() string {
+	if len(handler.Key) == 0 {
+		return DefaultSlogKey
+	}
+	return handler.Key
+}
+
+// Enabled returns false if the record level is under the handler's level or if
+// the next printer implements the Enabler interface and indicates that the
+// handler's key would be discarded.
+func (handler *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if handler.Level != nil && level < handler.Level.Level() {
+		return false
+	}
+
+	if enabler, ok := handler.Next.(Enabler); ok {
+		return enabler.Enabled(handler.key())
+	}
+
+	return true
+}
+
+// Handle converts the record into a line and forwards it to the next printer.
+// The trace and span ids carried by the context are attached to the line.
+func (handler *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	line := &Line{
+		Timestamp: record.Time,
+		Key:       handler.key(),
+		Value:     record.Message,
+		Level:     slogToLevel(record.Level),
+	}
+
+	if ctx != nil {
+		line.TraceID, line.SpanID = TraceFromContext(ctx)
+	}
+
+	if line.Timestamp.IsZero() {
+		line.Timestamp = time.Now()
+	}
+
+	if n := len(handler.fields) + record.NumAttrs(); n > 0 {
+		line.Fields = make(Fields, 0, n)
+		line.Fields = append(line.Fields, handler.fields...)
+
+		record.Attrs(func(attr slog.Attr) bool {
+			line.Fields = appendSlogAttr(line.Fields, "", attr)
+			return true
+		})
+	}
+
+	handler.Next.Print(line)
+	return nil
+}
+
+// WithAttrs returns a new handler which attaches the given attributes to all
+// the lines it creates.
+func (handler *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return handler
+	}
+
+	result := *handler
+	result.fields = make(Fields, 0, len(handler.fields)+len(attrs))
+	result.fields = append(result.fields, handler.fields...)
+
+	for _, attr := range attrs {
+		result.fields = appendSlogAttr(result.fields, "", attr)
+	}
+
+	return &result
+}
+
+// WithGroup returns a new handler whose key is the handler's key with the
+// given name appended as a new key segment.
+func (handler *SlogHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return handler
+	}
+
+	result := *handler
+	if len(handler.Key) == 0 {
+		result.Key = name
+	} else {
+		result.Key = handler.Key + "." + name
+	}
+
+	return &result
+}
+
+func appendSlogAttr(fields Fields, prefix string, attr slog.Attr) Fields {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	key := attr.Key
+	if len(prefix) > 0 {
+		key = prefix + "." + key
+	}
+
+	switch attr.Value.Kind() {
+
+	case slog.KindGroup:
+		for _, child := range attr.Value.Group() {
+			fields = appendSlogAttr(fields, key, child)
+		}
+		return fields
+
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return append(fields, Field{Key: key, Value: err.Error()})
+		}
+	}
+
+	return append(fields, Field{Key: key, Value: attr.Value.Any()})
+}
+
+func slogToLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func levelToSlog(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError, LevelFatal:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// SlogPrinter returns a printer which forwards all lines to the handler of the
+// given slog logger. The timestamp and level of the line are used as the
+// timestamp and level of the record while the key is added as the SlogKeyAttr
+// attribute followed by the trace and span ids, if any, and the fields of the
+// line. Lines without a level are logged at slog.LevelInfo.
+func SlogPrinter(logger *slog.Logger) Printer {
+	return PrinterFunc(func(line *Line) {
+		handler := logger.Handler()
+		level := levelToSlog(line.Level)
+
+		ctx := context.Background()
+		if !handler.Enabled(ctx, level) {
+			return
+		}
+
+		record := slog.NewRecord(line.Timestamp, level, line.Value, 0)
+		record.AddAttrs(slog.String(SlogKeyAttr, line.Key))
+
+		if len(line.TraceID) > 0 {
+			record.AddAttrs(slog.String(SlogTraceAttr, line.TraceID))
+		}
+
+		if len(line.SpanID) > 0 {
+			record.AddAttrs(slog.String(SlogSpanAttr, line.SpanID))
+		}
+
+		for _, field := range line.Fields {
+			record.AddAttrs(slog.Any(field.Key, field.Value))
+		}
+
+		handler.Handle(ctx, record)
+	})
+}
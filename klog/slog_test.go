@@ -0,0 +1,104 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSlogHandler(t *testing.T) {
+	out := &TestPrinter{T: t}
+	filter := NewFilter(FilterOut)
+	filter.Chain(out)
+	filter.Add("app.db")
+
+	logger := slog.New(NewSlogHandler(filter, "app"))
+
+	logger.Info("x", "a", 1)
+	logger.WithGroup("http").With("b", "y").Warn("y", slog.Group("req", "id", 2))
+	logger.WithGroup("db").Error("z")
+	logger.Debug("w", "err", errors.New("boom"))
+
+	lines := out.GetLines(3)
+	ExpectOrdered(t, Simplify(lines),
+		"<app> x",
+		"<app.http> y",
+		"<app> w",
+	)
+
+	if len(lines) != 3 {
+		t.FailNow()
+	}
+
+	expect := func(line *Line, level Level, fields string) {
+		if line.Level != level {
+			t.Errorf("FAIL: %s level %s != %s", line.Key, line.Level, level)
+		}
+		if line.Fields.String() != fields {
+			t.Errorf("FAIL: %s fields '%s' != '%s'", line.Key, line.Fields, fields)
+		}
+	}
+
+	expect(lines[0], LevelInfo, "a=1")
+	expect(lines[1], LevelWarn, "b=y req.id=2")
+	expect(lines[2], LevelDebug, "err=boom")
+}
+
+func TestSlogHandler_Enabled(t *testing.T) {
+	filter := NewFilter(FilterIn)
+	filter.Chain(NilPrinter)
+	filter.AddPrefix("app.http")
+
+	handler := NewSlogHandler(filter, "app")
+	handler.Level = slog.LevelInfo
+	ctx := context.Background()
+
+	if handler.Enabled(ctx, slog.LevelError) {
+		t.Error("FAIL: app should be disabled")
+	}
+
+	http := handler.WithGroup("http")
+
+	if !http.Enabled(ctx, slog.LevelInfo) {
+		t.Error("FAIL: app.http should be enabled")
+	}
+
+	if http.Enabled(ctx, slog.LevelDebug) {
+		t.Error("FAIL: debug should be disabled")
+	}
+}
+
+func TestSlogPrinter(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	ts := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	handler := slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelInfo})
+	printer := SlogPrinter(slog.New(handler))
+
+	printer.Print(&Line{Timestamp: ts, Key: "a.b", Value: "x", Level: LevelDebug})
+	printer.Print(&Line{Timestamp: ts, Key: "a.b", Value: "y", Level: LevelWarn, Fields: Fields{F("n", 1)}})
+	printer.Print(&Line{Timestamp: ts, Key: "a.b", Value: "z", TraceID: "t1", SpanID: "s1"})
+
+	exp := `{"time":"2014-01-02T03:04:05Z","level":"WARN","msg":"y","key":"a.b","n":1}` + "\n" +
+		`{"time":"2014-01-02T03:04:05Z","level":"INFO","msg":"z","key":"a.b","trace":"t1","span":"s1"}` + "\n"
+	if buffer.String() != exp {
+		t.Errorf("FAIL: '%s' != '%s'", buffer.String(), exp)
+	}
+}
+
+func TestSlogHandler_Trace(t *testing.T) {
+	out := &TestPrinter{T: t}
+	logger := slog.New(NewSlogHandler(out, "app"))
+
+	logger.InfoContext(WithTrace(context.Background(), "t1", "s1"), "x")
+
+	lines := out.GetLines(1)
+	if len(lines) != 1 || lines[0].TraceID != "t1" || lines[0].SpanID != "s1" {
+		t.Errorf("FAIL: unexpected lines %v", lines)
+	}
+}
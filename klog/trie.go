@@ -0,0 +1,186 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"strings"
+)
+
+// keyTrie indexes patterns on the "."-separated segments of keys such that the
+// cost of matching a key depends on the depth of the key instead of the number
+// of patterns. Prefix and suffix patterns keep the semantic of a string match
+// by allowing their last (or first) segment to only partially match a segment
+// of the key: the prefix "a.b" is indexed as the segment "a" followed by the
+// partial segment "b" which matches any key segment starting with "b". Matches
+// return the pattern that was hit.
+type keyTrie struct {
+	children map[string]*keyTrie
+	terminal bool
+	pattern  string
+	partials map[string]string
+}
+
+func newKeyTrie() *keyTrie { return new(keyTrie) }
+
+func (trie *keyTrie) child(segment string) *keyTrie {
+	if trie.children == nil {
+		trie.children = make(map[string]*keyTrie)
+	}
+
+	node, ok := trie.children[segment]
+	if !ok {
+		node = newKeyTrie()
+		trie.children[segment] = node
+	}
+
+	return node
+}
+
+func (trie *keyTrie) partial(segment, pattern string) {
+	if trie.partials == nil {
+		trie.partials = make(map[string]string)
+	}
+	trie.partials[segment] = pattern
+}
+
+// insertKey indexes a pattern which must match the full key.
+func (trie *keyTrie) insertKey(pattern string) {
+	node := trie
+	for _, segment := range strings.Split(pattern, ".") {
+		node = node.child(segment)
+	}
+	node.terminal = true
+	node.pattern = pattern
+}
+
+// insertPrefix indexes a pattern which must match the start of the key.
+func (trie *keyTrie) insertPrefix(pattern string) {
+	segments := strings.Split(pattern, ".")
+	last := len(segments) - 1
+
+	node := trie
+	for _, segment := range segments[:last] {
+		node = node.child(segment)
+	}
+	node.partial(segments[last], pattern)
+}
+
+// insertSuffix indexes a pattern which must match the end of the key. The
+// segments are indexed in reverse order.
+func (trie *keyTrie) insertSuffix(pattern string) {
+	segments := strings.Split(pattern, ".")
+
+	node := trie
+	for i := len(segments) - 1; i > 0; i-- {
+		node = node.child(segments[i])
+	}
+	node.partial(segments[0], pattern)
+}
+
+func (trie *keyTrie) matchKey(key string) (string, bool) {
+	node := trie
+
+	for {
+		i := strings.IndexByte(key, '.')
+
+		segment := key
+		if i >= 0 {
+			segment = key[:i]
+		}
+
+		if node = node.children[segment]; node == nil {
+			return "", false
+		}
+
+		if i < 0 {
+			return node.pattern, node.terminal
+		}
+
+		key = key[i+1:]
+	}
+}
+
+func (trie *keyTrie) matchPrefix(key string) (string, bool) {
+	node := trie
+
+	for {
+		i := strings.IndexByte(key, '.')
+
+		segment := key
+		if i >= 0 {
+			segment = key[:i]
+		}
+
+		for n := 0; len(node.partials) > 0 && n <= len(segment); n++ {
+			if pattern, ok := node.partials[segment[:n]]; ok {
+				return pattern, true
+			}
+		}
+
+		if i < 0 {
+			return "", false
+		}
+
+		if node = node.children[segment]; node == nil {
+			return "", false
+		}
+
+		key = key[i+1:]
+	}
+}
+
+func (trie *keyTrie) matchSuffix(key string) (string, bool) {
+	node := trie
+
+	for {
+		i := strings.LastIndexByte(key, '.')
+		segment := key[i+1:]
+
+		for n := len(segment); len(node.partials) > 0 && n >= 0; n-- {
+			if pattern, ok := node.partials[segment[n:]]; ok {
+				return pattern, true
+			}
+		}
+
+		if i < 0 {
+			return "", false
+		}
+
+		if node = node.children[segment]; node == nil {
+			return "", false
+		}
+
+		key = key[:i]
+	}
+}
+
+// matchLongestPrefix is the equivalent of matchPrefix which returns the longest
+// pattern matching the start of the key instead of the first one found.
+func (trie *keyTrie) matchLongestPrefix(key string) (result string, found bool) {
+	node := trie
+
+	for {
+		i := strings.IndexByte(key, '.')
+
+		segment := key
+		if i >= 0 {
+			segment = key[:i]
+		}
+
+		for n := 0; len(node.partials) > 0 && n <= len(segment); n++ {
+			if pattern, ok := node.partials[segment[:n]]; ok {
+				result, found = pattern, true
+			}
+		}
+
+		if i < 0 {
+			return
+		}
+
+		if node = node.children[segment]; node == nil {
+			return
+		}
+
+		key = key[i+1:]
+	}
+}
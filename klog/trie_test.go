@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func RandomKey(r *rand.Rand) string {
+	alphabet := []string{"a", "b", "ab", "ba", ".", "."}
+
+	n := r.Intn(6)
+	var key string
+	for i := 0; i < n; i++ {
+		key += alphabet[r.Intn(len(alphabet))]
+	}
+	return key
+}
+
+func TestKeyTrie(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	for i := 0; i < 100; i++ {
+		var patterns []string
+		keys, prefixes, suffixes := newKeyTrie(), newKeyTrie(), newKeyTrie()
+
+		for j := 0; j < 1+r.Intn(5); j++ {
+			pattern := RandomKey(r)
+			patterns = append(patterns, pattern)
+
+			keys.insertKey(pattern)
+			prefixes.insertPrefix(pattern)
+			suffixes.insertSuffix(pattern)
+		}
+
+		for j := 0; j < 100; j++ {
+			key := RandomKey(r)
+
+			var isKey, isPrefix, isSuffix bool
+			longest := ""
+			for _, pattern := range patterns {
+				isKey = isKey || key == pattern
+				isPrefix = isPrefix || strings.HasPrefix(key, pattern)
+				isSuffix = isSuffix || strings.HasSuffix(key, pattern)
+
+				if strings.HasPrefix(key, pattern) && len(pattern) > len(longest) {
+					longest = pattern
+				}
+			}
+
+			if pattern, ok := keys.matchKey(key); ok != isKey || (ok && key != pattern) {
+				t.Errorf("FAIL: key '%s' in %q: %t != %t (%s)", key, patterns, ok, isKey, pattern)
+			}
+
+			if pattern, ok := prefixes.matchPrefix(key); ok != isPrefix || (ok && !strings.HasPrefix(key, pattern)) {
+				t.Errorf("FAIL: prefix '%s' in %q: %t != %t (%s)", key, patterns, ok, isPrefix, pattern)
+			}
+
+			if pattern, ok := prefixes.matchLongestPrefix(key); ok != isPrefix || pattern != longest {
+				t.Errorf("FAIL: longest prefix '%s' in %q: %t != %t (%s != %s)", key, patterns, ok, isPrefix, pattern, longest)
+			}
+
+			if pattern, ok := suffixes.matchSuffix(key); ok != isSuffix || (ok && !strings.HasSuffix(key, pattern)) {
+				t.Errorf("FAIL: suffix '%s' in %q: %t != %t (%s)", key, patterns, ok, isSuffix, pattern)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Formatter converts a line into the bytes to be written to an output. The
+// returned bytes should be terminated by a newline.
+type Formatter func(*Line) []byte
+
+// FormatText formats the line as a single line of text prefixed by the line's
+// timestamp.
+func FormatText(line *Line) []byte {
+	return []byte(line.Timestamp.Format(time.RFC3339Nano) + " " + line.text() + "\n")
+}
+
+// FormatJSON formats the line as a single line json object. Fields attached to
+// the line are emitted as members of the json object.
+func FormatJSON(line *Line) []byte {
+	js, err := marshalLine(line, line.Key, line.Level.String())
+	if err != nil {
+		return FormatText(line)
+	}
+	return append(js, '\n')
+}
+
+// WriterPrinter writes all lines to an io.Writer. Writes are serialized so the
+// writer doesn't need to be safe for concurrent use.
+type WriterPrinter struct {
+	// Writer is where the formatted lines will be written to.
+	Writer io.Writer
+
+	// Format is used to format the lines. Defaults to FormatText.
+	Format Formatter
+
+	mutex sync.Mutex
+}
+
+// NewWriterPrinter creates a new WriterPrinter which writes all lines to the
+// given writer using the given format. If format is nil then FormatText is
+// used.
+func NewWriterPrinter(writer io.Writer, format Formatter) *WriterPrinter {
+	return &WriterPrinter{Writer: writer, Format: format}
+}
+
+// Print formats and writes the line to the writer. Write errors are ignored
+// since there's nowhere to report them.
+func (printer *WriterPrinter) Print(line *Line) {
+	format := printer.Format
+	if format == nil {
+		format = FormatText
+	}
+
+	buffer := format(line)
+
+	printer.mutex.Lock()
+	printer.Writer.Write(buffer)
+	printer.mutex.Unlock()
+}
+
+// LoggerPrinter returns a printer which forwards all lines to the given
+// standard library logger. Timestamps are handled by the logger according to
+// its flags.
+func LoggerPrinter(logger *log.Logger) Printer {
+	return PrinterFunc(func(line *Line) { logger.Print(line.text()) })
+}
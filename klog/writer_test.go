@@ -0,0 +1,42 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package klog
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestWriterPrinter(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	ts := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	text := NewWriterPrinter(buffer, nil)
+	text.Print(&Line{Timestamp: ts, Key: "a.b", Value: "x", Level: LevelInfo, Fields: Fields{F("n", 1)}})
+
+	if exp := "2014-01-02T03:04:05Z <a.b> [info] x n=1\n"; buffer.String() != exp {
+		t.Errorf("FAIL: '%s' != '%s'", buffer.String(), exp)
+	}
+
+	buffer.Reset()
+
+	js := NewWriterPrinter(buffer, FormatJSON)
+	js.Print(&Line{Timestamp: ts, Key: "a.b", Value: "x", Fields: Fields{F("n", 1)}})
+
+	if exp := `{"ts":"2014-01-02T03:04:05Z","key":"a.b","val":"x","n":1}` + "\n"; buffer.String() != exp {
+		t.Errorf("FAIL: '%s' != '%s'", buffer.String(), exp)
+	}
+}
+
+func TestLoggerPrinter(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	printer := LoggerPrinter(log.New(buffer, "test ", 0))
+	printer.Print(L("a.b", "x"))
+
+	if exp := "test <a.b> x\n"; buffer.String() != exp {
+		t.Errorf("FAIL: '%s' != '%s'", buffer.String(), exp)
+	}
+}